@@ -58,29 +58,26 @@ func main() {
 		fmt.Println(err.Reason)
 	})
 
-	listener := subscription.Listener{
-		OnData: func(data pdu.SubscriptionData) {
-			for _, message := range data.Messages {
-				var frame Frame
-
-				// Try to unmarshal message to Frame struct
-				err := json.Unmarshal(message, &frame)
-				if err == nil {
-					fmt.Printf("Got frame: %+v\n", frame)
-				} else {
-					fmt.Println("Failed to parse the incoming message:", string(message))
-				}
+	listener := rtm.TypedListener[Frame]{
+		OnData: func(frames []Frame) {
+			for _, frame := range frames {
+				fmt.Printf("Got frame: %+v\n", frame)
 			}
 		},
-		OnSubscribed: func(sok pdu.SubscribeOk) {
-			fmt.Println("Subscribed to the channel:", sok.SubscriptionId)
+		OnDecodeError: func(message json.RawMessage, err error) {
+			fmt.Println("Failed to parse the incoming message:", string(message))
 		},
-		OnSubscribeError: func(err pdu.SubscribeError) {
-			fmt.Println("Failed to subscribe:", err.Error, err.Reason)
+		Listener: subscription.Listener{
+			OnSubscribed: func(sok pdu.SubscribeOk) {
+				fmt.Println("Subscribed to the channel:", sok.SubscriptionId)
+			},
+			OnSubscribeError: func(err pdu.SubscribeError) {
+				fmt.Println("Failed to subscribe:", err.Error, err.Reason)
+			},
 		},
 	}
 
-	client.Subscribe(CHANNEL, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, listener)
+	rtm.SubscribeTyped(client, CHANNEL, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, listener)
 	client.Start()
 
 	<-connected
@@ -90,7 +87,7 @@ func main() {
 			Id:      frame_id,
 			Payload: []uint8{12, 42, 0, 1, 255, 100},
 		}
-		response := <-client.PublishAck(CHANNEL, frame)
+		response := <-rtm.PublishTyped(client, CHANNEL, frame)
 		if response.Err == nil {
 			fmt.Printf("Frame is published: %+v\n", frame)
 		} else {
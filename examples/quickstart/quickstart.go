@@ -95,42 +95,41 @@ func main() {
 	})
 
 	// We create a subscription listener in order to receive callbacks
-	// for incoming data, state changes and errors.
+	// for incoming data, state changes and errors. rtm.TypedListener decodes incoming messages
+	// to the Animal struct, so we do not need to unmarshal them by hand.
 	//
 	// The full list of available subscription events is here:
 	// https://godoc.org/github.com/satori-com/satori-rtm-sdk-go/rtm#hdr-SUBSCRIPTIONS
-	listener := subscription.Listener{
+	listener := rtm.TypedListener[Animal]{
 		// In this callback we will process all incoming messages
 		// Be aware: All callbacks MUST NOT block the main thread. You should use go-routines in cases if you need
 		// to wait for some data/events/etc.
-		OnData: func(data pdu.SubscriptionData) {
-			for _, message := range data.Messages {
-				var animal Animal
-
-				// Try to unmarshal message to Animal struct
-				err := json.Unmarshal(message, &animal)
-				if err == nil {
-					fmt.Printf("Got animal %s: %+v\n", animal.Who, animal.Where)
-				} else {
-					// We failed to convert the message to the Animal struct.
-					fmt.Println("Failed to parse the incoming message:", string(message))
-				}
+		OnData: func(animals []Animal) {
+			for _, animal := range animals {
+				fmt.Printf("Got animal %s: %+v\n", animal.Who, animal.Where)
 			}
 		},
 
-		// Called when the subscription is established.
-		OnSubscribed: func(sok pdu.SubscribeOk) {
-			fmt.Println("Subscribed to the channel:", sok.SubscriptionId)
+		// Called for every message that cannot be converted to the Animal struct
+		OnDecodeError: func(message json.RawMessage, err error) {
+			fmt.Println("Failed to parse the incoming message:", string(message))
 		},
 
-		// Called when failed to subscribe
-		OnSubscribeError: func(err pdu.SubscribeError) {
-			fmt.Println("Failed to subscribe:", err.Error, err.Reason)
-		},
-
-		// Called when getting the unsolicited error
-		OnSubscriptionError: func(err pdu.SubscriptionError) {
-			fmt.Printf("Subscription failed. RTM sent the unsolicited error %s: %s\n", err.Error, err.Reason)
+		Listener: subscription.Listener{
+			// Called when the subscription is established.
+			OnSubscribed: func(sok pdu.SubscribeOk) {
+				fmt.Println("Subscribed to the channel:", sok.SubscriptionId)
+			},
+
+			// Called when failed to subscribe
+			OnSubscribeError: func(err pdu.SubscribeError) {
+				fmt.Println("Failed to subscribe:", err.Error, err.Reason)
+			},
+
+			// Called when getting the unsolicited error
+			OnSubscriptionError: func(err pdu.SubscriptionError) {
+				fmt.Printf("Subscription failed. RTM sent the unsolicited error %s: %s\n", err.Error, err.Reason)
+			},
 		},
 	}
 
@@ -140,7 +139,7 @@ func main() {
 	//
 	// Satori Docs: Subscribing
 	// https://www.satori.com/docs/using-satori/subscribing
-	client.Subscribe(CHANNEL, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, listener)
+	rtm.SubscribeTyped(client, CHANNEL, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, listener)
 
 	// Now we start the client. After that client will establish connection to the Satori endpoint,
 	// pass the authentication and subscribe to the channel.
@@ -169,7 +168,7 @@ func main() {
 				Who:   "zebra",
 				Where: [2]float32{lat, lon},
 			}
-			response := <-client.PublishAck(CHANNEL, animal)
+			response := <-rtm.PublishTyped(client, CHANNEL, animal)
 			if response.Err == nil {
 				// Publish is confirmed by Satori RTM.
 				fmt.Printf("Animal is published: %+v\n", animal)
@@ -50,35 +50,33 @@ func main() {
 	// For synchronisation reason we will use typed channel (type Animal) to be able to collect all incoming messages
 	animals := make(chan Animal)
 
-	listener := subscription.Listener{
-		OnSubscribed: func(sok pdu.SubscribeOk) {
-			fmt.Println("Subscribed to: " + sok.SubscriptionId)
-		},
-		OnUnsubscribed: func(response pdu.UnsubscribeBodyResponse) {
-			fmt.Println("Unsubscribed from: " + response.SubscriptionId)
-		},
-		OnData: func(data pdu.SubscriptionData) {
-			for _, message := range data.Messages {
-				var animal Animal
-
-				// We assume, that the message in the channel has an Animal struct.
-				err := json.Unmarshal(message, &animal)
-				if err == nil {
-					animals <- animal
-				} else {
-					fmt.Println("Failed to handle the incoming message:", string(message))
-				}
+	listener := rtm.TypedListener[Animal]{
+		OnData: func(data []Animal) {
+			for _, animal := range data {
+				animals <- animal
 			}
 		},
-		OnSubscribeError: func(err pdu.SubscribeError) {
-			fmt.Printf("Failed to subscribe %s: %s\n", err.Error, err.Reason)
+		// We assume, that the messages in the channel have an Animal struct.
+		OnDecodeError: func(message json.RawMessage, err error) {
+			fmt.Println("Failed to handle the incoming message:", string(message))
 		},
-		OnSubscriptionError: func(err pdu.SubscriptionError) {
-			fmt.Printf("Subscription failed. RTM sent the unsolicited error %s: %s\n", err.Error, err.Reason)
+		Listener: subscription.Listener{
+			OnSubscribed: func(sok pdu.SubscribeOk) {
+				fmt.Println("Subscribed to: " + sok.SubscriptionId)
+			},
+			OnUnsubscribed: func(response pdu.UnsubscribeBodyResponse) {
+				fmt.Println("Unsubscribed from: " + response.SubscriptionId)
+			},
+			OnSubscribeError: func(err pdu.SubscribeError) {
+				fmt.Printf("Failed to subscribe %s: %s\n", err.Error, err.Reason)
+			},
+			OnSubscriptionError: func(err pdu.SubscriptionError) {
+				fmt.Printf("Subscription failed. RTM sent the unsolicited error %s: %s\n", err.Error, err.Reason)
+			},
 		},
 	}
 
-	client.Subscribe("animals", subscription.SIMPLE, pdu.SubscribeBodyOpts{}, listener)
+	rtm.SubscribeTyped(client, "animals", subscription.SIMPLE, pdu.SubscribeBodyOpts{}, listener)
 	client.Start()
 
 	// Now we have a subscription that will forward all incoming messages to our go-channel.
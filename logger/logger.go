@@ -46,7 +46,7 @@ func Fatal(e error) {
 }
 
 func printError(e error) {
-	fmt.Fprintf(os.Stderr, "%s%+v\n", e)
+	fmt.Fprintf(os.Stderr, "%+v\n", e)
 	if os.Getenv("DEBUG_SATORI_SDK") == "true" || DEBUG_SATORI_SDK {
 		buf := make([]byte, 1<<16)
 		runtime.Stack(buf, false)
@@ -22,6 +22,8 @@ const (
 type Observer struct {
 	events     map[string]*list.List
 	eventQueue chan observerEvent
+	done       chan struct{}
+	closed     int32
 	id         int32
 }
 
@@ -57,6 +59,7 @@ func New() Observer {
 func (o *Observer) initEvents() {
 	o.events = make(map[string]*list.List)
 	o.eventQueue = make(chan observerEvent, EVENT_QUEUE_LEN)
+	o.done = make(chan struct{})
 
 	go o.handleQueue()
 }
@@ -79,28 +82,59 @@ func (o *Observer) Once(eventName string, callback func(interface{})) interface{
 
 // Unsubscribes from an event. Use the id from the Observer.On() to remove callback function
 func (o *Observer) Off(eventName string, id interface{}) {
-	o.eventQueue <- observerEvent{
+	o.enqueue(observerEvent{
 		Type: "unregister",
 		Data: unregisterEvent{
 			eventName: eventName,
 			id:        id,
 		},
-	}
+	})
 }
 
 // Fires event. Executes callback functions and passes data to them
 func (o *Observer) Fire(eventName string, data interface{}) {
-	o.eventQueue <- observerEvent{
+	o.enqueue(observerEvent{
 		Type: "fire",
 		Data: fireEvent{
 			eventName: eventName,
 			data:      data,
 		},
+	})
+}
+
+// Stops processing events and releases the events goroutine. Events that are not processed yet are dropped,
+// On, Once, Off and Fire calls after Close have no effect.
+//
+// Close does not wait for the callback that is running at the moment, so it can be called from callbacks.
+func (o *Observer) Close() {
+	if atomic.CompareAndSwapInt32(&o.closed, 0, 1) {
+		close(o.done)
+	}
+}
+
+func (o *Observer) enqueue(event observerEvent) {
+	if atomic.LoadInt32(&o.closed) == 1 {
+		return
+	}
+	select {
+	case o.eventQueue <- event:
+	case <-o.done:
 	}
 }
 
 func (o *Observer) handleQueue() {
-	for event := range o.eventQueue {
+	for {
+		var event observerEvent
+		select {
+		case event = <-o.eventQueue:
+		case <-o.done:
+			return
+		}
+		// Select picks a random ready case: do not process queued events after Close
+		if atomic.LoadInt32(&o.closed) == 1 {
+			return
+		}
+
 		switch event.Type {
 		case "register":
 			e := event.Data.(callbackT)
@@ -141,7 +175,7 @@ func (o *Observer) deleteCallback(eventName string, id interface{}) {
 
 func (o *Observer) addCallback(eventName string, callback func(interface{}), onetime bool) interface{} {
 	id := o.nextId()
-	o.eventQueue <- observerEvent{
+	o.enqueue(observerEvent{
 		Type: "register",
 		Data: callbackT{
 			eventName: eventName,
@@ -149,7 +183,7 @@ func (o *Observer) addCallback(eventName string, callback func(interface{}), one
 			onetime:   onetime,
 			id:        id,
 		},
-	}
+	})
 
 	return id
 }
@@ -139,3 +139,27 @@ func TestOff(t *testing.T) {
 		t.Fatal("Unregister method does not work")
 	}
 }
+
+func TestClose(t *testing.T) {
+	a := A{
+		Observer: New(),
+	}
+
+	event := make(chan bool, 1)
+	a.On("event", func(data interface{}) {
+		event <- true
+	})
+	a.Close()
+	a.Close()
+
+	// Does not block even if the queue is full
+	for i := 0; i < EVENT_QUEUE_LEN*2; i++ {
+		a.Fire("event", nil)
+	}
+	a.On("event", func(data interface{}) {})
+	select {
+	case <-event:
+		t.Fatal("Event is fired after Close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -10,6 +10,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/md5"
 	"encoding/base64"
@@ -25,7 +26,7 @@ var (
 )
 
 type Auth struct {
-	conn       *connection.Connection
+	conn       connection.Transport
 	role       string
 	roleSecret string
 }
@@ -44,23 +45,27 @@ func New(role, roleSecret string) *Auth {
 	return auth
 }
 
-func (auth *Auth) Authenticate(conn *connection.Connection) error {
+func (auth *Auth) Authenticate(conn connection.Transport) error {
 	auth.conn = conn
 
 	logger.Info("Auth: Starting authentication")
 	action := "auth/handshake"
 	body := json.RawMessage(`{ "method": "role_secret", "data": {"role": "` + auth.role + `"} }`)
 
-	ch, err := auth.conn.SendAck(action, body)
+	ch, err := auth.conn.SendAckCtx(context.Background(), action, body)
 
 	if err != nil {
 		return err
 	}
 
-	handshake, ok := <-ch
+	ack, ok := <-ch
 	if !ok {
 		return ERROR_BROKEN_CONNECTION
 	}
+	if ack.Err != nil {
+		return ack.Err
+	}
+	handshake := ack.Response
 	if pdu.GetResponseCode(handshake) != pdu.CODE_OK_REQUEST {
 		return pdu.GetResponseError(handshake)
 	}
@@ -78,12 +83,19 @@ func (auth *Auth) Authenticate(conn *connection.Connection) error {
 
 	action = "auth/authenticate"
 	body = json.RawMessage(`{"method": "role_secret", "credentials": {"hash": "` + hash + `"} }`)
-	ch, err = auth.conn.SendAck(action, body)
+	ch, err = auth.conn.SendAckCtx(context.Background(), action, body)
+	if err != nil {
+		return err
+	}
 
-	authenticated, ok := <-ch
+	ack, ok = <-ch
 	if !ok {
 		return ERROR_BROKEN_CONNECTION
 	}
+	if ack.Err != nil {
+		return ack.Err
+	}
+	authenticated := ack.Response
 	if pdu.GetResponseCode(authenticated) != pdu.CODE_OK_REQUEST {
 		return pdu.GetResponseError(authenticated)
 	}
@@ -0,0 +1,166 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"sync"
+	"time"
+)
+
+var (
+	ERROR_CONNECTION_LOST = errors.New("Connection lost before RTM responded")
+	ERROR_ACK_TIMEOUT     = errors.New("RTM did not respond in time")
+)
+
+// Outcome of a request sent with SendAckResult. Either Response or Err is set
+type Ack struct {
+	Response pdu.RTMQuery
+	Err      error
+}
+
+// Tracks in-flight requests. Every request is completed exactly once: whoever
+// removes the request from the listeners map under the mutex delivers the outcome
+type acksType struct {
+	listeners map[string]*pendingAck
+	timeout   time.Duration
+	closed    bool
+	mutex     sync.Mutex
+}
+
+type pendingAck struct {
+	ch    chan Ack
+	done  chan struct{}
+	timer *time.Timer
+
+	// Frees the slot in the in-flight window
+	release func()
+
+	// Stream requests receive every response with the id until the /ok or /error one.
+	// mutex guards finished and sending of intermediate responses
+	stream   bool
+	finished bool
+	mutex    sync.Mutex
+}
+
+func (c *Connection) initAcks(timeout time.Duration) {
+	c.acks.listeners = make(map[string]*pendingAck, MAX_ACKS_QUEUE_LENGTH)
+	c.acks.timeout = timeout
+}
+
+func (c *Connection) addListener(ctx context.Context, id string, stream bool) (<-chan Ack, error) {
+	c.acks.mutex.Lock()
+	defer c.acks.mutex.Unlock()
+
+	if c.acks.closed {
+		return nil, ERROR_CONNECTION_LOST
+	}
+
+	size := 1
+	if stream {
+		size = MAX_UNPROCESSED_ACKS_QUEUE
+	}
+	p := &pendingAck{
+		ch:      make(chan Ack, size),
+		done:    make(chan struct{}),
+		release: c.window.release,
+		stream:  stream,
+	}
+	if c.acks.timeout > 0 {
+		p.timer = time.AfterFunc(c.acks.timeout, func() {
+			c.completeAck(id, Ack{
+				Err: ERROR_ACK_TIMEOUT,
+			})
+		})
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.completeAck(id, Ack{
+					Err: ctx.Err(),
+				})
+			case <-p.done:
+			}
+		}()
+	}
+	c.acks.listeners[id] = p
+
+	return p.ch, nil
+}
+
+// Delivers the response to the request listener. Intermediate responses to stream requests are passed
+// to the listener without completing the request. Blocks while the go-channel of the stream is full
+func (c *Connection) deliverAck(id string, ack Ack) {
+	c.acks.mutex.Lock()
+	p, ok := c.acks.listeners[id]
+	c.acks.mutex.Unlock()
+	if !ok {
+		return
+	}
+	if !p.stream || pdu.GetResponseCode(ack.Response) != pdu.CODE_BAD_REQUEST {
+		c.completeAck(id, ack)
+		return
+	}
+
+	if p.timer != nil {
+		p.timer.Reset(c.acks.timeout)
+	}
+	p.mutex.Lock()
+	if !p.finished {
+		select {
+		case p.ch <- ack:
+		case <-p.done:
+		}
+	}
+	p.mutex.Unlock()
+}
+
+// Delivers the outcome to the request listener. Does nothing if the request is already completed
+func (c *Connection) completeAck(id string, ack Ack) {
+	c.acks.mutex.Lock()
+	p, ok := c.acks.listeners[id]
+	delete(c.acks.listeners, id)
+	c.acks.mutex.Unlock()
+
+	if ok {
+		p.complete(ack)
+	}
+}
+
+// Completes all in-flight requests with the error. No new requests are accepted after that
+func (c *Connection) failAllAcks(err error) {
+	c.acks.mutex.Lock()
+	listeners := c.acks.listeners
+	c.acks.listeners = make(map[string]*pendingAck)
+	c.acks.closed = true
+	c.acks.mutex.Unlock()
+
+	for _, p := range listeners {
+		p.complete(Ack{
+			Err: err,
+		})
+	}
+}
+
+func (p *pendingAck) complete(ack Ack) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	close(p.done)
+	p.release()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.finished = true
+	select {
+	case p.ch <- ack:
+		close(p.ch)
+	default:
+		// The stream listener has not read the intermediate responses yet
+		go func() {
+			p.ch <- ack
+			close(p.ch)
+		}()
+	}
+}
@@ -0,0 +1,196 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+const (
+	// Length of the queue of PDUs waiting for the writer goroutine
+	WRITE_QUEUE_LENGTH = 1024
+
+	// Maximum number of PDUs written to the socket at once
+	MAX_WRITE_BATCH = 256
+)
+
+var (
+	ERROR_TOO_MANY_IN_FLIGHT = errors.New("Too many requests waiting for the response")
+)
+
+// PDU waiting for the writer goroutine
+type writeRequest struct {
+	messageType int
+	message     []byte
+}
+
+// Limits the number of requests waiting for the response
+type inFlightWindow struct {
+	slots chan struct{}
+	block bool
+}
+
+func newInFlightWindow(size int, block bool) *inFlightWindow {
+	if size <= 0 {
+		return nil
+	}
+	return &inFlightWindow{
+		slots: make(chan struct{}, size),
+		block: block,
+	}
+}
+
+// Takes a slot in the window. Blocks until there is a free slot if the window is configured to block,
+// otherwise fails with ERROR_TOO_MANY_IN_FLIGHT
+func (w *inFlightWindow) acquire(ctx context.Context, closed <-chan struct{}) error {
+	if w == nil {
+		return nil
+	}
+
+	select {
+	case w.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if !w.block {
+		return ERROR_TOO_MANY_IN_FLIGHT
+	}
+
+	select {
+	case w.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closed:
+		return ERROR_CONNECTION_LOST
+	}
+}
+
+func (w *inFlightWindow) release() {
+	if w != nil {
+		<-w.slots
+	}
+}
+
+// Network connection that holds written data in memory while the batch is open,
+// so several WebSocket frames are sent to the network with one write
+type batchConn struct {
+	net.Conn
+
+	mutex    sync.Mutex
+	batching bool
+	buffer   bytes.Buffer
+}
+
+func (c *batchConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.batching {
+		return c.buffer.Write(p)
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *batchConn) startBatch() {
+	c.mutex.Lock()
+	c.batching = true
+	c.mutex.Unlock()
+}
+
+// Writes the batch to the network
+func (c *batchConn) flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.batching = false
+	if c.buffer.Len() == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+// Wraps the dial function to batch writes to the network connection
+func batchDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error), conn **batchConn) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		netConn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		*conn = &batchConn{Conn: netConn}
+		return *conn, nil
+	}
+}
+
+// Starts the writer goroutine. PDUs sent by concurrent callers are queued and written in batches:
+// the writer takes all queued PDUs and sends them to the network with one write
+func (c *Connection) initWriter(netConn *batchConn) {
+	c.writeQueue = make(chan writeRequest, WRITE_QUEUE_LENGTH)
+	c.batchConn = netConn
+
+	go func() {
+		for {
+			var request writeRequest
+			select {
+			case request = <-c.writeQueue:
+			case <-c.closed:
+				return
+			}
+
+			if err := c.writeBatch(request); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}()
+}
+
+func (c *Connection) writeBatch(first writeRequest) error {
+	c.wSockMutex.Lock()
+	defer c.wSockMutex.Unlock()
+
+	c.batchConn.startBatch()
+	err := c.writeFrame(first.messageType, first.message)
+	for i := 1; i < MAX_WRITE_BATCH && err == nil; i++ {
+		var request writeRequest
+		select {
+		case request = <-c.writeQueue:
+		default:
+			return c.batchConn.flush()
+		}
+		err = c.writeFrame(request.messageType, request.message)
+	}
+	if flushErr := c.batchConn.flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// Writes the WebSocket frame. Must be called with wSockMutex locked
+func (c *Connection) writeFrame(messageType int, message []byte) error {
+	if c.compressionThreshold > 0 {
+		c.wsConn.EnableWriteCompression(len(message) >= c.compressionThreshold)
+	}
+	return c.wsConn.WriteMessage(messageType, message)
+}
+
+// Queues the PDU for the writer goroutine. Blocks if the queue is full
+func (c *Connection) enqueueWrite(messageType int, message []byte) error {
+	select {
+	case <-c.closed:
+		return ERROR_CONNECTION_LOST
+	default:
+	}
+
+	select {
+	case c.writeQueue <- writeRequest{messageType, message}:
+		return nil
+	case <-c.closed:
+		return ERROR_CONNECTION_LOST
+	}
+}
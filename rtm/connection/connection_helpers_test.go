@@ -1,9 +1,27 @@
 package connection
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
 )
 
 type credentialsT struct {
@@ -37,3 +55,188 @@ func getCredentials() (credentialsT, error) {
 
 	return credentials, err
 }
+
+// Starts a TLS server with a self-signed certificate that expired a day ago
+func newExpiredTLSServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{
+			generateCertificate(t, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour)),
+		},
+	}
+	srv.StartTLS()
+	return srv
+}
+
+// Generates a self-signed certificate for 127.0.0.1
+func generateCertificate(t *testing.T, notBefore, notAfter time.Time) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+// Starts a minimal SOCKS5 proxy without authentication that supports the CONNECT command only.
+// Returns the proxy address and the go-channel that receives an event for every proxied connection.
+func newSOCKS5Proxy(t *testing.T) (string, <-chan bool) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	proxied := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				proxied <- true
+
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), proxied
+}
+
+func socks5Handshake(conn net.Conn) (net.Conn, error) {
+	// Greeting: VER, NMETHODS, METHODS
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return nil, err
+	}
+	conn.Write([]byte{5, 0})
+
+	// Request: VER, CMD, RSV, ATYP, DST.ADDR, DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		return nil, errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return target, nil
+}
+
+// Counts bytes written to the network connection
+type countingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+// Returns a dial function that counts bytes written by all dialed connections
+func countingDialer(written *int64) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return countingConn{conn, written}, nil
+	}
+}
+
+// Builds a JSON message similar to a typical subscription message
+func largeMessage(size int) json.RawMessage {
+	var items []string
+	length := 0
+	for i := 0; length < size; i++ {
+		item := `{"id":` + strconv.Itoa(i) + `,"name":"sensor-` + strconv.Itoa(i%10) + `","value":` + strconv.Itoa(i*7%1000) + `}`
+		items = append(items, item)
+		length += len(item) + 1
+	}
+	return json.RawMessage(`[` + strings.Join(items, ",") + `]`)
+}
+
+// Counts calls to Write, i.e. write syscalls, of all dialed connections
+type writesCountingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c writesCountingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func writesCountingDialer(writes *int64) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return writesCountingConn{conn, writes}, nil
+	}
+}
@@ -1,8 +1,21 @@
 package connection
 
 import (
+	"compress/flate"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -15,23 +28,32 @@ func TestEmptyEndpoint(t *testing.T) {
 }
 
 func TestBadSSLSelfSigned(t *testing.T) {
-	_, err := New("wss://self-signed.badssl.com/", Options{})
-	if !strings.Contains(err.Error(), "certificate signed by unknown authority") {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := New("wss"+strings.TrimPrefix(srv.URL, "https"), Options{})
+	if err == nil || !strings.Contains(err.Error(), "certificate signed by unknown authority") {
 		t.Fatal("Connected to host with self-signed certificate")
 	}
 }
 
 func TestBadSSLExpired(t *testing.T) {
-	_, err := New("wss://expired.badssl.com/", Options{})
-	if !strings.Contains(err.Error(), "certificate has expired") {
+	srv := newExpiredTLSServer(t)
+	defer srv.Close()
+
+	_, err := New("wss"+strings.TrimPrefix(srv.URL, "https"), Options{})
+	if err == nil || !strings.Contains(err.Error(), "certificate has expired") {
 		t.Fatal("Connected to host with expired certificate")
 	}
 }
 
 func TestBasicConnection(t *testing.T) {
-	conn, err := New("ws://echo.websocket.org/", Options{})
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	conn, err := New(srv.URL, Options{})
 	if err != nil {
-		t.Fatal("Unable to connect to ws://echo.websocket.org/")
+		t.Fatal("Unable to connect to " + srv.URL)
 	}
 
 	conn.Close()
@@ -137,3 +159,936 @@ func TestMaxNextID(t *testing.T) {
 		t.Fatal("Unable to reset lastID. Int overflow")
 	}
 }
+
+func TestSendAckCtx(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	conn, err := New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Read()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := conn.SendAckCtx(ctx, "test", json.RawMessage("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case ack := <-resp:
+		if ack.Err != context.Canceled {
+			t.Fatal("Got response after the context is canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request did not complete after the context is canceled")
+	}
+
+	conn.acks.mutex.Lock()
+	defer conn.acks.mutex.Unlock()
+	if len(conn.acks.listeners) != 0 {
+		t.Fatal("Ack listener was not released")
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	conn, err := New(srv.URL, Options{
+		AckTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Read()
+
+	resp, err := conn.SendAckResult("test", json.RawMessage("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ack := <-resp:
+		if ack.Err != ERROR_ACK_TIMEOUT {
+			t.Fatal("Request did not fail with ERROR_ACK_TIMEOUT")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request did not time out")
+	}
+
+	if _, ok := <-resp; ok {
+		t.Fatal("Request completed more than once")
+	}
+}
+
+func TestCloseFailsPendingAcks(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	conn, err := New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.Read()
+
+	var pending []<-chan Ack
+	for i := 0; i < 10; i++ {
+		resp, err := conn.SendAckResult("test", json.RawMessage("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, resp)
+	}
+
+	// Drop the connection on the server side
+	srv.CloseConnections()
+
+	for _, resp := range pending {
+		select {
+		case ack := <-resp:
+			if ack.Err != ERROR_CONNECTION_LOST {
+				t.Fatal("Pending request did not fail with ERROR_CONNECTION_LOST:", ack.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Pending request did not complete after connection is lost")
+		}
+	}
+
+	if _, err := conn.SendAckResult("test", json.RawMessage("{}")); err != ERROR_CONNECTION_LOST {
+		t.Fatal("Sent request using closed connection")
+	}
+}
+
+func TestSendAck(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Reply(query, "ok", "response")
+	})
+	srv.HandleFunc("never", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	conn, err := New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.Read()
+
+	resp, err := conn.SendAck("test", json.RawMessage("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case query := <-resp:
+		if query.Action != "test/ok" || string(query.Body) != `"response"` {
+			t.Fatal("Wrong response:", query.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unable to get response")
+	}
+
+	resp, err = conn.SendAck("never", json.RawMessage("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case _, ok := <-resp:
+		if ok {
+			t.Fatal("Got response after the connection is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Go-channel is not closed after the connection is closed")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	conn, err := New(srv.URL, Options{
+		PingInterval: 20 * time.Millisecond,
+		PongTimeout:  20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read()
+		readErr <- err
+	}()
+
+	// Server answers pings. Connection stays alive without any traffic
+	select {
+	case err := <-readErr:
+		t.Fatal("Alive connection is closed:", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	srv.IgnorePings(true)
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("Read did not fail on dead connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dead connection was not detected")
+	}
+}
+
+func TestTLSRootCAs(t *testing.T) {
+	srv := rtmtest.NewTLSServer()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	conn, err := New(srv.URL, Options{
+		TLSClientConfig: &tls.Config{
+			RootCAs: pool,
+		},
+	})
+	if err != nil {
+		t.Fatal("Unable to connect using custom root CA:", err)
+	}
+	conn.Close()
+}
+
+func TestPinnedPublicKeys(t *testing.T) {
+	srv := rtmtest.NewTLSServer()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tlsConfig := &tls.Config{
+		RootCAs: pool,
+	}
+
+	conn, err := New(srv.URL, Options{
+		TLSClientConfig:  tlsConfig,
+		PinnedPublicKeys: []string{"wrong-pin", PublicKeyPin(srv.Certificate())},
+	})
+	if err != nil {
+		t.Fatal("Unable to connect with matching pin:", err)
+	}
+	conn.Close()
+
+	_, err = New(srv.URL, Options{
+		TLSClientConfig:  tlsConfig,
+		PinnedPublicKeys: []string{"wrong-pin"},
+	})
+	if err == nil || !strings.Contains(err.Error(), ERROR_PIN_MISMATCH.Error()) {
+		t.Fatal("Connected with mismatched pin")
+	}
+
+	// Pins are checked even if the certificate chain is not verified
+	_, err = New(srv.URL, Options{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		PinnedPublicKeys: []string{"wrong-pin"},
+	})
+	if err == nil {
+		t.Fatal("Connected with mismatched pin and InsecureSkipVerify")
+	}
+	if tlsConfig.VerifyConnection != nil {
+		t.Fatal("User TLS config was modified")
+	}
+}
+
+func TestPinnedPublicKeys_ResumedSession(t *testing.T) {
+	srv := rtmtest.NewTLSServer()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tlsConfig := &tls.Config{
+		RootCAs:            pool,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+
+	conn, err := New(srv.URL, Options{
+		TLSClientConfig:  tlsConfig,
+		PinnedPublicKeys: []string{PublicKeyPin(srv.Certificate())},
+	})
+	if err != nil {
+		t.Fatal("Unable to connect with matching pin:", err)
+	}
+	conn.Close()
+
+	// The session is resumed from the cache, the certificate is not sent again
+	_, err = New(srv.URL, Options{
+		TLSClientConfig:  tlsConfig,
+		PinnedPublicKeys: []string{"wrong-pin"},
+	})
+	if err == nil || !strings.Contains(err.Error(), ERROR_PIN_MISMATCH.Error()) {
+		t.Fatal("Resumed session is accepted with mismatched pin:", err)
+	}
+}
+
+func TestPinnedPublicKeys_ForeignChain(t *testing.T) {
+	pinnedCert := generateCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	pinnedLeaf, _ := x509.ParseCertificate(pinnedCert.Certificate[0])
+	foreignCert := generateCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	// The server owns the foreign key only, but appends the pinned certificate to the chain
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{foreignCert.Certificate[0], pinnedCert.Certificate[0]},
+			PrivateKey:  foreignCert.PrivateKey,
+		}},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	_, err := New("wss://"+srv.Listener.Addr().String(), Options{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		PinnedPublicKeys: []string{PublicKeyPin(pinnedLeaf)},
+	})
+	if err == nil || !strings.Contains(err.Error(), ERROR_PIN_MISMATCH.Error()) {
+		t.Fatal("Pinned certificate appended to a foreign chain is accepted:", err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	clientCert := generateCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	clientPool := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(clientCert.Certificate[0])
+	clientPool.AddCert(leaf)
+
+	srv := rtmtest.NewUnstartedServer()
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientPool,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	_, err := New(srv.URL, Options{
+		TLSClientConfig: &tls.Config{
+			RootCAs: pool,
+		},
+	})
+	if err == nil {
+		t.Fatal("Connected without client certificate")
+	}
+
+	conn, err := New(srv.URL, Options{
+		TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{clientCert},
+		},
+	})
+	if err != nil {
+		t.Fatal("Unable to connect with client certificate:", err)
+	}
+	conn.Close()
+}
+
+func TestHeader(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Reply(query, "ok", conn.Header().Get("X-Trace-Id"))
+	})
+
+	conn, err := New(srv.URL, Options{
+		Header: http.Header{"X-Trace-Id": []string{"trace-1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Read()
+
+	ch, _ := conn.SendAckResult("test", nil)
+	ack := <-ch
+	if string(ack.Response.Body) != `"trace-1"` {
+		t.Fatal("Header was not sent:", string(ack.Response.Body))
+	}
+}
+
+func TestNetDialContext(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srvURL, _ := url.Parse(srv.URL)
+
+	var dialedAddr string
+	conn, err := New("ws://sidecar.invalid/", Options{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialedAddr = addr
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", srvURL.Host)
+		},
+	})
+	if err != nil {
+		t.Fatal("Unable to connect using custom dialer:", err)
+	}
+	conn.Close()
+
+	if dialedAddr != "sidecar.invalid:80" {
+		t.Fatal("Wrong address passed to dialer:", dialedAddr)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	// Accepts TCP connections, but never responds
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	_, err = New("ws://"+listener.Addr().String()+"/", Options{
+		HandshakeTimeout: 100 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("Connected to the silent server")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Handshake timeout is ignored")
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	proxyAddr, proxied := newSOCKS5Proxy(t)
+	proxyURL, _ := url.Parse("socks5://" + proxyAddr)
+
+	conn, err := New(srv.URL, Options{
+		Proxy: http.ProxyURL(proxyURL),
+	})
+	if err != nil {
+		t.Fatal("Unable to connect via SOCKS5 proxy:", err)
+	}
+	conn.Close()
+
+	select {
+	case <-proxied:
+	default:
+		t.Fatal("Connection did not go through the proxy")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	message := largeMessage(16 * 1024)
+
+	sent := func(opts Options, message json.RawMessage) int64 {
+		var written int64
+		opts.NetDialContext = countingDialer(&written)
+		conn, err := New(srv.URL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		before := atomic.LoadInt64(&written)
+		if err := conn.Send("test", message); err != nil {
+			t.Fatal(err)
+		}
+		return atomic.LoadInt64(&written) - before
+	}
+
+	plain := sent(Options{}, message)
+	compressed := sent(Options{EnableCompression: true}, message)
+	if compressed*2 > plain {
+		t.Fatalf("Message is not compressed: %d bytes plain, %d bytes compressed", plain, compressed)
+	}
+
+	threshold := sent(Options{EnableCompression: true, CompressionThreshold: len(message) + 100}, message)
+	if threshold != plain {
+		t.Fatalf("Message below threshold is compressed: %d bytes plain, %d bytes sent", plain, threshold)
+	}
+
+	if _, err := New(srv.URL, Options{EnableCompression: true, CompressionLevel: 100}); err == nil {
+		t.Fatal("Connected with invalid compression level")
+	}
+}
+
+func benchmarkCompression(b *testing.B, opts Options, size int) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	var written int64
+	opts.NetDialContext = countingDialer(&written)
+	conn, err := New(srv.URL, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	message := largeMessage(size)
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	start := atomic.LoadInt64(&written)
+	for i := 0; i < b.N; i++ {
+		if err := conn.Send("test", message); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&written)-start)/float64(b.N), "wire-B/op")
+}
+
+func BenchmarkCompression_None_1KB(b *testing.B) {
+	benchmarkCompression(b, Options{}, 1024)
+}
+
+func BenchmarkCompression_BestSpeed_1KB(b *testing.B) {
+	benchmarkCompression(b, Options{EnableCompression: true, CompressionLevel: flate.BestSpeed}, 1024)
+}
+
+func BenchmarkCompression_None_64KB(b *testing.B) {
+	benchmarkCompression(b, Options{}, 64*1024)
+}
+
+func BenchmarkCompression_BestSpeed_64KB(b *testing.B) {
+	benchmarkCompression(b, Options{EnableCompression: true, CompressionLevel: flate.BestSpeed}, 64*1024)
+}
+
+func BenchmarkCompression_Default_64KB(b *testing.B) {
+	benchmarkCompression(b, Options{EnableCompression: true, CompressionLevel: flate.DefaultCompression}, 64*1024)
+}
+
+func BenchmarkCompression_BestCompression_64KB(b *testing.B) {
+	benchmarkCompression(b, Options{EnableCompression: true, CompressionLevel: flate.BestCompression}, 64*1024)
+}
+
+func BenchmarkCompression_Threshold_1KB(b *testing.B) {
+	benchmarkCompression(b, Options{EnableCompression: true, CompressionThreshold: 4096}, 1024)
+}
+
+func TestCBORCodec(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	binary := make(chan bool, 1)
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		_, isCBOR := conn.Codec().(pdu.CBORCodec)
+		binary <- isCBOR
+		conn.Reply(query, "ok", query.Body)
+	})
+
+	conn, err := New(srv.URL, Options{
+		Codec: pdu.CBORCodec{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Read()
+
+	ch, err := conn.SendValueAckCtx(context.Background(), "test", map[string]interface{}{
+		"payload": []byte{1, 2, 3},
+		"text":    "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := <-ch
+	if !<-binary {
+		t.Fatal("CBOR subprotocol is not negotiated")
+	}
+	if ack.Err != nil || string(ack.Response.Body) != `{"payload":"AQID","text":"hello"}` {
+		t.Fatal("Wrong response:", ack.Err, string(ack.Response.Body))
+	}
+
+	if _, err := conn.SendValueAckCtx(context.Background(), "test", func() {}); err == nil {
+		t.Fatal("Unsupported value was sent")
+	} else if _, ok := err.(EncodeError); !ok {
+		t.Fatal("Wrong error type:", err)
+	}
+}
+
+func TestSubprotocolNotSupported(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader.Upgrade(w, r, nil)
+	}))
+	defer srv.Close()
+
+	_, err := New("ws"+strings.TrimPrefix(srv.URL, "http"), Options{
+		Codec: pdu.CBORCodec{},
+	})
+	if err != ERROR_SUBPROTOCOL_NOT_SUPPORTED {
+		t.Fatal("Wrong error returned:", err)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	conn, err := New(srv.URL, Options{
+		MaxInFlight: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Read()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		if _, err := conn.SendAckCtx(ctx, "test", json.RawMessage("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.SendAckResult("test", json.RawMessage("{}")); err != ERROR_TOO_MANY_IN_FLIGHT {
+		t.Fatal("Request is sent when the in-flight window is full:", err)
+	}
+
+	// Completed requests free the window
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := conn.SendAckResult("test", json.RawMessage("{}"))
+		if err == nil {
+			break
+		}
+		if err != ERROR_TOO_MANY_IN_FLIGHT || time.Now().After(deadline) {
+			t.Fatal("In-flight window is not released:", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxInFlightBlock(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	release := make(chan struct{})
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		<-release
+		conn.Reply(query, "ok", nil)
+	})
+	srv.HandleFunc("never", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	conn, err := New(srv.URL, Options{
+		MaxInFlight:        1,
+		BlockOnMaxInFlight: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, err := conn.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	first, err := conn.SendAckResult("test", json.RawMessage("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The context bounds the waiting for a free slot
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.SendAckCtx(ctx, "never", json.RawMessage("{}")); err != context.DeadlineExceeded {
+		t.Fatal("Request did not wait for a free slot:", err)
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := conn.SendAckResult("never", json.RawMessage("{}"))
+		sent <- err
+	}()
+	select {
+	case <-sent:
+		t.Fatal("Request is sent when the in-flight window is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if ack := <-first; ack.Err != nil {
+		t.Fatal(ack.Err)
+	}
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Blocked request is not sent after the slot is freed")
+	}
+
+	// Closing the connection unblocks waiting requests
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Close()
+	}()
+	if _, err := conn.SendAckResult("never", json.RawMessage("{}")); err != ERROR_CONNECTION_LOST {
+		t.Fatal("Blocked request did not fail after the connection is closed:", err)
+	}
+}
+
+func TestWriteBatching(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Reply(query, "ok", query.Body)
+	})
+
+	var writes int64
+	conn, err := New(srv.URL, Options{
+		EnableWriteBatching: true,
+		NetDialContext:      writesCountingDialer(&writes),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, err := conn.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	const count = 500
+	start := atomic.LoadInt64(&writes)
+	var pending []<-chan Ack
+	for i := 0; i < count; i++ {
+		resp, err := conn.SendAckResult("test", json.RawMessage(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, resp)
+	}
+
+	for i, resp := range pending {
+		select {
+		case ack := <-resp:
+			if ack.Err != nil {
+				t.Fatal(ack.Err)
+			}
+			if string(ack.Response.Body) != strconv.Itoa(i) {
+				t.Fatal("Wrong response:", string(ack.Response.Body))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Request is not sent")
+		}
+	}
+
+	if sent := atomic.LoadInt64(&writes) - start; sent >= count {
+		t.Fatal("PDUs are not batched:", sent, "writes for", count, "PDUs")
+	}
+}
+
+func TestWriteBatchingClose(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	conn, err := New(srv.URL, Options{
+		EnableWriteBatching: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if err := conn.Send("test", json.RawMessage("{}")); err != ERROR_CONNECTION_LOST {
+		t.Fatal("PDU is queued after the connection is closed:", err)
+	}
+}
+
+// Publishes with acknowledge without waiting for every response, like a producer that streams
+// events and collects the acknowledges in the background
+func benchmarkPublishAck(b *testing.B, opts Options) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Reply(query, "ok", pdu.PublishBodyResponse{Position: "1:0"})
+	})
+
+	var writes int64
+	opts.NetDialContext = writesCountingDialer(&writes)
+	opts.MaxInFlight = 1000
+	opts.BlockOnMaxInFlight = true
+	conn, err := New(srv.URL, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, err := conn.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	pending := make(chan (<-chan Ack), 1000)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for resp := range pending {
+			if ack := <-resp; ack.Err != nil {
+				b.Error(ack.Err)
+			}
+		}
+	}()
+
+	body := json.RawMessage(`{"channel":"bench","message":{"id":1,"name":"sensor-1","value":42}}`)
+	b.ResetTimer()
+	start := atomic.LoadInt64(&writes)
+	for i := 0; i < b.N; i++ {
+		resp, err := conn.SendAckResult("rtm/publish", body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pending <- resp
+	}
+	close(pending)
+	<-done
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&writes)-start)/float64(b.N), "writes/op")
+}
+
+func BenchmarkPublishAck_Unbatched(b *testing.B) {
+	benchmarkPublishAck(b, Options{})
+}
+
+func BenchmarkPublishAck_Batched(b *testing.B) {
+	benchmarkPublishAck(b, Options{EnableWriteBatching: true})
+}
+
+func TestSendStreamCtx(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	const count = MAX_UNPROCESSED_ACKS_QUEUE * 2
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		for i := 0; i < count; i++ {
+			conn.Send(pdu.RTMQuery{
+				Action: "test/data",
+				Id:     query.Id,
+				Body:   json.RawMessage(strconv.Itoa(i)),
+			})
+		}
+		conn.Reply(query, "ok", nil)
+	})
+
+	conn, err := New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			if _, err := conn.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	resp, err := conn.SendStreamCtx(context.Background(), "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Let the go-channel fill up
+	time.Sleep(50 * time.Millisecond)
+
+	for i := 0; i < count; i++ {
+		select {
+		case ack := <-resp:
+			if ack.Err != nil || ack.Response.Action != "test/data" || string(ack.Response.Body) != strconv.Itoa(i) {
+				t.Fatal("Wrong response:", ack)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Response is not received")
+		}
+	}
+	if ack := <-resp; ack.Response.Action != "test/ok" {
+		t.Fatal("Terminal response is not received:", ack)
+	}
+	if _, ok := <-resp; ok {
+		t.Fatal("Stream is not closed after the terminal response")
+	}
+}
+
+func TestSendStreamCtx_Canceled(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("test", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Send(pdu.RTMQuery{
+			Action: "test/data",
+			Id:     query.Id,
+		})
+		// Never complete
+	})
+
+	conn, err := New(srv.URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Read()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := conn.SendStreamCtx(ctx, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack := <-resp; ack.Response.Action != "test/data" {
+		t.Fatal("Intermediate response is not received:", ack)
+	}
+	cancel()
+
+	select {
+	case ack := <-resp:
+		if ack.Err != context.Canceled {
+			t.Fatal("Stream did not fail after the context is canceled:", ack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not complete after the context is canceled")
+	}
+	if _, ok := <-resp; ok {
+		t.Fatal("Stream is not closed")
+	}
+}
@@ -0,0 +1,71 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+)
+
+var (
+	ERROR_PIN_MISMATCH = errors.New("Certificate public key does not match any pinned key")
+)
+
+// Gets the pin for the certificate: base64-encoded SHA-256 hash of the Subject Public Key Info.
+// The same format is used by HTTP Public Key Pinning ("pin-sha256").
+//
+// Use the following command to get the pin for the certificate file:
+//   openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func PublicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Returns TLS config that additionally verifies the certificate public keys against the pins
+func pinnedTLSConfig(config *tls.Config, pins []string) *tls.Config {
+	if len(pins) == 0 {
+		return config
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	pinned := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		pinned[pin] = true
+	}
+
+	// VerifyConnection is called for resumed sessions too, unlike VerifyPeerCertificate
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+
+		// Check the verified chains. If verification is skipped, check only the leaf certificate:
+		// the server proves it owns the leaf key in the handshake, other presented certificates can be
+		// appended by anyone
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain {
+				if pinned[PublicKeyPin(cert)] {
+					return nil
+				}
+			}
+		}
+		if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 {
+			if pinned[PublicKeyPin(state.PeerCertificates[0])] {
+				return nil
+			}
+		}
+
+		return ERROR_PIN_MISMATCH
+	}
+
+	return config
+}
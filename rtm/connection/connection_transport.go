@@ -0,0 +1,49 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+)
+
+// Transport sends and receives PDUs. *Connection is the WebSocket implementation.
+//
+// Implement Transport to run the RTM client over an in-memory pipe in tests, to inject faults
+// or to use an alternative transport.
+type Transport interface {
+	// Sends the PDU without waiting for the response
+	Send(action string, body json.RawMessage) error
+
+	// Sends the PDU with a new id. The go-channel receives exactly one Ack: the response with the same id
+	// or an error (ERROR_CONNECTION_LOST, ERROR_ACK_TIMEOUT, ctx.Err()) and then is closed.
+	SendAckCtx(ctx context.Context, action string, body json.RawMessage) (<-chan Ack, error)
+
+	// Blocks until the next PDU is received. Responses to SendAckCtx requests are also passed
+	// to the corresponding go-channels. Returns an error if the transport is broken or closed.
+	Read() (pdu.RTMQuery, error)
+
+	// Closes the transport. Pending SendAckCtx requests complete with ERROR_CONNECTION_LOST
+	Close()
+}
+
+// ValueTransport is implemented by transports that encode the PDU body themselves,
+// e.g. to send []byte values as native CBOR byte strings. If the transport does not implement it,
+// the body is marshaled to JSON before sending.
+type ValueTransport interface {
+	Transport
+
+	SendValue(action string, body interface{}) error
+	SendValueAckCtx(ctx context.Context, action string, body interface{}) (<-chan Ack, error)
+}
+
+// StreamTransport is implemented by transports that pass several responses to one request,
+// e.g. rtm/search. The go-channel receives every response with the id of the request until
+// the terminal "/ok" or "/error" response or an error, and then is closed.
+type StreamTransport interface {
+	Transport
+
+	SendStreamCtx(ctx context.Context, action string, body interface{}) (<-chan Ack, error)
+}
+
+var _ ValueTransport = (*Connection)(nil)
+var _ StreamTransport = (*Connection)(nil)
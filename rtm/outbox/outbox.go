@@ -0,0 +1,555 @@
+// Durable outbox for at-least-once publishing.
+//
+// Outbox persists outgoing messages to a local append-only log before sending them with PublishAck
+// and removes them when RTM confirms delivery with "rtm/publish/ok". Messages that are not acknowledged
+// (the client is disconnected, the process dies, etc.) are re-sent when the client connects
+// and when the outbox is opened again:
+//
+//   client, _ := rtm.New("<your-endpoint>", "<your-appkey>", rtm.Options{})
+//   box, err := outbox.Open(client, "/var/lib/app/audit.outbox", outbox.Options{
+//     Sync:    outbox.SYNC_ALWAYS,
+//     MaxSize: 64 * 1024 * 1024,
+//   })
+//   if err != nil {
+//     log.Fatal(err)
+//   }
+//   defer box.Close()
+//   client.Start()
+//
+//   response := <-box.Publish("audit", event)
+//
+// Messages can be delivered more than once, e.g. if the process dies after RTM received the message
+// but before the acknowledge is written to the log. Consumers should de-duplicate messages if needed.
+//
+// Messages that RTM rejects as invalid, e.g. with "invalid_format" error, are removed from the log.
+// Messages that fail with other RTM errors, e.g. "authorization_denied", are kept and re-sent on the next connect.
+//
+// Messages are stored and re-sent as JSON: []byte messages are sent as base64 strings.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/logger"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defines when the log is flushed to the disk
+type SyncPolicy int
+
+const (
+	// Flushes the log after every message before it is sent. Messages survive OS crashes and power loss
+	SYNC_ALWAYS SyncPolicy = iota
+
+	// Flushes the log every SyncInterval. Messages written since the last flush can be lost
+	// on OS crash or power loss, but survive process crashes
+	SYNC_INTERVAL
+
+	// Never flushes the log explicitly and relies on the OS
+	SYNC_NEVER
+)
+
+const DEFAULT_SYNC_INTERVAL = time.Second
+
+var (
+	ERROR_OUTBOX_FULL      = errors.New("Outbox is full")
+	ERROR_OUTBOX_CLOSED    = errors.New("Outbox is closed")
+	ERROR_OUTBOX_CORRUPTED = errors.New("Outbox log is corrupted")
+)
+
+type Options struct {
+	// Defines when the log is flushed to the disk. Defaults to SYNC_ALWAYS
+	Sync SyncPolicy
+
+	// Flush interval for SYNC_INTERVAL policy. Defaults to DEFAULT_SYNC_INTERVAL
+	SyncInterval time.Duration
+
+	// Maximum size of the log in bytes. Publish fails with ERROR_OUTBOX_FULL if the message does not fit
+	// into the log even after acknowledged messages are removed. Zero means no limit
+	MaxSize int64
+}
+
+type Outbox struct {
+	client *rtm.RTMClient
+	path   string
+	opts   Options
+
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+	dirty   bool
+	closed  bool
+	lastId  uint64
+	pending map[uint64]*entry
+
+	// Set if a partial record could not be removed from the end of the log.
+	// The log is compacted before the next write
+	broken bool
+
+	connectedId interface{}
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+type entry struct {
+	id       uint64
+	channel  string
+	message  json.RawMessage
+	inflight bool
+}
+
+// Log record. Publish records carry the message, acknowledge records only carry the id
+type record struct {
+	Id      uint64          `json:"id"`
+	Ack     bool            `json:"ack,omitempty"`
+	Channel string          `json:"channel,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+// Opens the outbox log at the path, creating it if it does not exist, and re-sends unacknowledged messages.
+// Messages are sent immediately if the client is connected, otherwise when the client connects.
+//
+// Only one Outbox should use the log at a time. Fails with ERROR_OUTBOX_CORRUPTED if a record
+// in the middle of the log cannot be parsed: the log is left untouched to be repaired manually.
+func Open(client *rtm.RTMClient, path string, opts Options) (*Outbox, error) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DEFAULT_SYNC_INTERVAL
+	}
+
+	o := &Outbox{
+		client:  client,
+		path:    path,
+		opts:    opts,
+		pending: make(map[uint64]*entry),
+		stop:    make(chan struct{}),
+	}
+
+	if err := o.replay(); err != nil {
+		return nil, err
+	}
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+	if len(o.pending) > 0 {
+		logger.Info("Outbox: Found", len(o.pending), "unacknowledged messages in", path)
+	}
+
+	if opts.Sync == SYNC_INTERVAL {
+		o.wg.Add(1)
+		go o.syncLoop()
+	}
+
+	o.connectedId = client.OnConnected(o.resend)
+	if client.IsConnected() {
+		o.resend()
+	}
+
+	return o, nil
+}
+
+// Writes the message to the log and publishes it with acknowledge.
+// Returns the channel that will receive the response to the first publish attempt.
+//
+// If the message is not acknowledged, it stays in the log and is re-sent when the client connects
+// or the outbox is opened again. The message is not stored if the response error reason is
+// ERROR_OUTBOX_FULL, ERROR_OUTBOX_CLOSED or an I/O error.
+func (o *Outbox) Publish(channel string, message interface{}) <-chan rtm.PublishResponse {
+	e, err := o.append(channel, message)
+	if err != nil {
+		retCh := make(chan rtm.PublishResponse, 1)
+		retCh <- rtm.PublishResponse{
+			Err: rtm.RTMError{
+				Code:   rtm.ERROR_CODE_APPLICATION,
+				Reason: err,
+			},
+		}
+		close(retCh)
+		return retCh
+	}
+
+	return o.send(e)
+}
+
+// Returns the number of messages that are not acknowledged yet
+func (o *Outbox) Pending() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.pending)
+}
+
+// Flushes and closes the log. Unacknowledged messages are re-sent when the outbox is opened again.
+// Acknowledges received after Close are not recorded.
+func (o *Outbox) Close() error {
+	o.mutex.Lock()
+	if o.closed {
+		o.mutex.Unlock()
+		return nil
+	}
+	o.closed = true
+	close(o.stop)
+
+	var err error
+	if o.opts.Sync != SYNC_NEVER {
+		err = o.file.Sync()
+	}
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	o.mutex.Unlock()
+
+	o.client.Off(rtm.EVENT_CONNECTED, o.connectedId)
+	o.wg.Wait()
+	return err
+}
+
+func (o *Outbox) append(channel string, message interface{}) (*entry, error) {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed {
+		return nil, ERROR_OUTBOX_CLOSED
+	}
+
+	e := &entry{
+		id:       o.lastId + 1,
+		channel:  channel,
+		message:  raw,
+		inflight: true,
+	}
+	line, err := encodeRecord(record{Id: e.id, Channel: channel, Message: raw})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.opts.MaxSize > 0 && o.size+int64(len(line)) > o.opts.MaxSize {
+		if err := o.compact(); err != nil {
+			return nil, err
+		}
+		if o.size+int64(len(line)) > o.opts.MaxSize {
+			return nil, ERROR_OUTBOX_FULL
+		}
+	}
+
+	if err := o.write(line); err != nil {
+		return nil, err
+	}
+	if o.opts.Sync == SYNC_ALWAYS {
+		if err := o.file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	o.lastId = e.id
+	o.pending[e.id] = e
+	return e, nil
+}
+
+// Publishes the entry and records the acknowledge. The entry must be marked as in-flight
+func (o *Outbox) send(e *entry) <-chan rtm.PublishResponse {
+	retCh := make(chan rtm.PublishResponse, 1)
+	ch := o.client.PublishAck(e.channel, e.message)
+
+	go func() {
+		defer close(retCh)
+		response := <-ch
+		o.complete(e, response)
+		retCh <- response
+	}()
+
+	return retCh
+}
+
+// Records the outcome of the publish request
+func (o *Outbox) complete(e *entry, response rtm.PublishResponse) {
+	if response.Err == nil || isRejected(response.Err) {
+		if response.Err != nil {
+			logger.Warn("Outbox: RTM rejected the message, removing it from the log:", response.Err)
+		}
+		o.acknowledge(e)
+		return
+	}
+
+	o.mutex.Lock()
+	e.inflight = false
+	o.mutex.Unlock()
+
+	// The client could connect before the entry stopped being in-flight, so the resend
+	// on connect skipped it. RTM errors are retried on the next connect only
+	if !isServerError(response.Err) && o.client.IsConnected() {
+		o.resend()
+	}
+}
+
+// Sends all pending messages that are not in-flight, in the order they were published
+func (o *Outbox) resend() {
+	o.mutex.Lock()
+	if o.closed {
+		o.mutex.Unlock()
+		return
+	}
+	var entries []*entry
+	for _, e := range o.pending {
+		if !e.inflight {
+			e.inflight = true
+			entries = append(entries, e)
+		}
+	}
+	o.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id < entries[j].id
+	})
+	if len(entries) > 0 {
+		logger.Info("Outbox: Re-sending", len(entries), "unacknowledged messages")
+	}
+	for _, e := range entries {
+		o.send(e)
+	}
+}
+
+func (o *Outbox) acknowledge(e *entry) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed {
+		return
+	}
+	if _, ok := o.pending[e.id]; !ok {
+		return
+	}
+	delete(o.pending, e.id)
+
+	// Nothing to keep: start the log from scratch instead of appending the acknowledge
+	if len(o.pending) == 0 {
+		if err := o.truncate(); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+
+	line, err := encodeRecord(record{Id: e.id, Ack: true})
+	if err == nil {
+		err = o.write(line)
+	}
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// Flushes the log periodically for SYNC_INTERVAL policy
+func (o *Outbox) syncLoop() {
+	defer o.wg.Done()
+	ticker := time.NewTicker(o.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.mutex.Lock()
+			if o.dirty && !o.closed {
+				if err := o.file.Sync(); err != nil {
+					logger.Error(err)
+				}
+				o.dirty = false
+			}
+			o.mutex.Unlock()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Reads the log and restores pending messages. A broken last record (e.g. the process died in the middle
+// of a write) is ignored and removed by the following compaction. Broken records before the last one
+// fail with ERROR_OUTBOX_CORRUPTED: skipping them could lose messages or re-send acknowledged ones
+func (o *Outbox) replay() error {
+	file, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logger.Warn("Outbox: Ignoring incomplete record at the end of", o.path)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil || r.Id == 0 {
+			if _, err := reader.Peek(1); err == io.EOF {
+				logger.Warn("Outbox: Ignoring broken record at the end of", o.path)
+				return nil
+			}
+			logger.Warn("Outbox: Broken record at line", number, "of", o.path)
+			return ERROR_OUTBOX_CORRUPTED
+		}
+
+		if r.Id > o.lastId {
+			o.lastId = r.Id
+		}
+		if r.Ack {
+			delete(o.pending, r.Id)
+		} else {
+			o.pending[r.Id] = &entry{
+				id:      r.Id,
+				channel: r.Channel,
+				message: r.Message,
+			}
+		}
+	}
+}
+
+// Rewrites the log to keep only pending messages. The new log replaces the old one atomically.
+// Must be called with the mutex locked
+func (o *Outbox) compact() error {
+	ids := make([]uint64, 0, len(o.pending))
+	for id := range o.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		e := o.pending[id]
+		line, err := encodeRecord(record{Id: e.id, Channel: e.channel, Message: e.message})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+	}
+
+	tmpPath := o.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if o.opts.Sync != SYNC_NEVER {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, o.path); err != nil {
+		return err
+	}
+	if o.opts.Sync != SYNC_NEVER {
+		syncDir(filepath.Dir(o.path))
+	}
+
+	file, err := os.OpenFile(o.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file = file
+	o.size = int64(buf.Len())
+	o.dirty = false
+	o.broken = false
+	return nil
+}
+
+// Empties the log. Must be called with the mutex locked
+func (o *Outbox) truncate() error {
+	if err := o.file.Truncate(0); err != nil {
+		return err
+	}
+	o.size = 0
+	o.dirty = true
+	o.broken = false
+	return nil
+}
+
+// Appends the record to the log. Must be called with the mutex locked
+func (o *Outbox) write(line []byte) error {
+	if o.broken {
+		if err := o.compact(); err != nil {
+			return err
+		}
+	}
+	if _, err := o.file.Write(line); err != nil {
+		// Remove the partial record, otherwise it would corrupt the following records
+		if truncateErr := o.file.Truncate(o.size); truncateErr != nil {
+			logger.Error(truncateErr)
+			o.broken = true
+		}
+		return err
+	}
+	o.size += int64(len(line))
+	o.dirty = true
+	return nil
+}
+
+func encodeRecord(r record) ([]byte, error) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// Makes the rename durable. Not all platforms support syncing directories, so errors are ignored
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// Reports whether RTM responded with "rtm/publish/error"
+func isServerError(err error) bool {
+	rtmErr, ok := err.(rtm.RTMError)
+	if !ok || rtmErr.Code != rtm.ERROR_CODE_APPLICATION {
+		return false
+	}
+	_, ok = rtmErr.Reason.(pdu.ServerError)
+	return ok
+}
+
+// Reports whether RTM rejected the message as invalid, e.g. with "invalid_format" error. Such messages
+// are not re-sent because RTM would reject them again. Other errors, e.g. "authorization_denied",
+// can clear up after re-authentication, so the messages are kept
+func isRejected(err error) bool {
+	if !isServerError(err) {
+		return false
+	}
+	switch err.(rtm.RTMError).Reason.(pdu.ServerError).Unwrap() {
+	case pdu.ERROR_INVALID_FORMAT, pdu.ERROR_JSON_PARSE_ERROR, pdu.ERROR_INVALID_SERVICE:
+		return true
+	}
+	return false
+}
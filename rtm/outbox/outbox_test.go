@@ -0,0 +1,427 @@
+package outbox
+
+import (
+	"encoding/json"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mutex    sync.Mutex
+	messages []string
+	received chan string
+}
+
+// Records messages published to the server
+func newRecorder(srv *rtmtest.Server) *recorder {
+	r := &recorder{received: make(chan string, 100)}
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		var body struct {
+			Message json.RawMessage `json:"message"`
+		}
+		json.Unmarshal(query.Body, &body)
+		r.mutex.Lock()
+		r.messages = append(r.messages, string(body.Message))
+		r.mutex.Unlock()
+		conn.Reply(query, "ok", pdu.PublishBodyResponse{Position: "1"})
+		r.received <- string(body.Message)
+	})
+	return r
+}
+
+func (r *recorder) wait(t *testing.T, expected string) {
+	select {
+	case message := <-r.received:
+		if message != expected {
+			t.Fatal("Wrong message received:", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message is not re-sent")
+	}
+}
+
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test.outbox"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func connectedClient(t *testing.T, srv *rtmtest.Server) *rtm.RTMClient {
+	client, _ := rtm.New(srv.URL, "local-appkey", rtm.Options{})
+	connected := make(chan bool, 1)
+	client.OnConnectedOnce(func() {
+		connected <- true
+	})
+	client.Start()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client is not connected")
+	}
+	return client
+}
+
+// Client that is never connected: all requests fail with ERROR_NOT_CONNECTED
+func offlineClient() *rtm.RTMClient {
+	client, _ := rtm.New("ws://127.0.0.1:1", "local-appkey", rtm.Options{})
+	return client
+}
+
+func waitPending(t *testing.T, box *Outbox, expected int) {
+	for i := 0; i < 500; i++ {
+		if box.Pending() == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Wrong number of pending messages:", box.Pending())
+}
+
+func TestOutbox_Acknowledged(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	client := connectedClient(t, srv)
+	defer client.Stop()
+
+	box, err := Open(client, path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	for i := 0; i < 3; i++ {
+		response := <-box.Publish("channel", i)
+		if response.Err != nil {
+			t.Fatal(response.Err)
+		}
+	}
+	if box.Pending() != 0 {
+		t.Fatal("Acknowledged messages are not removed")
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Fatal("Log is not truncated when all messages are acknowledged")
+	}
+}
+
+func TestOutbox_ResendOnRestart(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	r := newRecorder(srv)
+
+	box, err := Open(offlineClient(), path, Options{Sync: SYNC_INTERVAL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"first", "second"} {
+		response := <-box.Publish("channel", message)
+		if err, ok := response.Err.(rtm.RTMError); !ok || err.Reason != rtm.ERROR_NOT_CONNECTED {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	}
+	if box.Pending() != 2 {
+		t.Fatal("Unacknowledged messages are not kept")
+	}
+	box.Close()
+
+	response := <-box.Publish("channel", "closed")
+	if err, ok := response.Err.(rtm.RTMError); !ok || err.Reason != ERROR_OUTBOX_CLOSED {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+
+	client := connectedClient(t, srv)
+	defer client.Stop()
+	box, err = Open(client, path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	r.wait(t, `"first"`)
+	r.wait(t, `"second"`)
+	waitPending(t, box, 0)
+
+	// New messages get new ids
+	if response := <-box.Publish("channel", "third"); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	r.wait(t, `"third"`)
+}
+
+func TestOutbox_ResendOnConnect(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	r := newRecorder(srv)
+
+	client, _ := rtm.New(srv.URL, "local-appkey", rtm.Options{})
+	defer client.Stop()
+	box, err := Open(client, path, Options{Sync: SYNC_NEVER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	if response := <-box.Publish("channel", "offline"); response.Err == nil {
+		t.Fatal("Message is sent while the client is not connected")
+	}
+
+	client.Start()
+	r.wait(t, `"offline"`)
+	waitPending(t, box, 0)
+}
+
+func TestOutbox_FailedWhileConnecting(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	path, cleanup := tempPath(t)
+	defer cleanup()
+	r := newRecorder(srv)
+
+	client, _ := rtm.New(srv.URL, "local-appkey", rtm.Options{})
+	defer client.Stop()
+	box, err := Open(client, path, Options{Sync: SYNC_NEVER})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	// The request is still in flight when the client connects, so the resend on connect skips it
+	e, err := box.append("channel", "late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan bool, 1)
+	client.OnConnectedOnce(func() {
+		connected <- true
+	})
+	client.Start()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client is not connected")
+	}
+
+	box.complete(e, rtm.PublishResponse{
+		Err: rtm.RTMError{
+			Code:   rtm.ERROR_CODE_APPLICATION,
+			Reason: rtm.ERROR_NOT_CONNECTED,
+		},
+	})
+	r.wait(t, `"late"`)
+	waitPending(t, box, 0)
+}
+
+func TestOutbox_Rejected(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.ReplyError(query, "invalid_format", "Invalid channel")
+	})
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	client := connectedClient(t, srv)
+	defer client.Stop()
+	box, err := Open(client, path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	if response := <-box.Publish("channel", "message"); response.Err == nil {
+		t.Fatal("Invalid message is not rejected")
+	}
+	if box.Pending() != 0 {
+		t.Fatal("Rejected message is kept in the log")
+	}
+}
+
+func TestOutbox_AuthorizationDenied(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.RestrictChannel("restricted")
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	client := connectedClient(t, srv)
+	defer client.Stop()
+	box, err := Open(client, path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	if response := <-box.Publish("restricted", "message"); response.Err == nil {
+		t.Fatal("Restricted channel is not rejected")
+	}
+	if box.Pending() != 1 {
+		t.Fatal("Message is removed from the log after authorization_denied error")
+	}
+}
+
+func TestOutbox_MaxSize(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	box, err := Open(offlineClient(), path, Options{MaxSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	var response rtm.PublishResponse
+	for i := 0; i < 10; i++ {
+		response = <-box.Publish("channel", "0123456789")
+		if err, ok := response.Err.(rtm.RTMError); ok && err.Reason == ERROR_OUTBOX_FULL {
+			break
+		}
+	}
+	if err, ok := response.Err.(rtm.RTMError); !ok || err.Reason != ERROR_OUTBOX_FULL {
+		t.Fatal("Log size is not limited")
+	}
+	if info, _ := os.Stat(path); info.Size() > 200 {
+		t.Fatal("Log exceeds MaxSize:", info.Size())
+	}
+}
+
+func TestOutbox_BrokenTail(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	log := `{"id":1,"channel":"channel","message":"first"}` + "\n" +
+		`{"id":2,"channel":"channel","message":"second"}` + "\n" +
+		`{"id":1,"ack":true}` + "\n" +
+		`{"id":3,"channel":"chan`
+	if err := ioutil.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	box, err := Open(offlineClient(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	if box.Pending() != 1 {
+		t.Fatal("Wrong number of pending messages:", box.Pending())
+	}
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != `{"id":2,"channel":"channel","message":"second"}`+"\n" {
+		t.Fatal("Log is not compacted:", string(data))
+	}
+}
+
+func TestOutbox_BrokenLastRecord(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	log := `{"id":1,"channel":"channel","message":"first"}` + "\n" +
+		`{"id":2,"chan` + "\n"
+	if err := ioutil.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	box, err := Open(offlineClient(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	if box.Pending() != 1 {
+		t.Fatal("Wrong number of pending messages:", box.Pending())
+	}
+}
+
+func TestOutbox_CorruptedRecord(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	log := `{"id":1,"channel":"channel","message":"first"}` + "\n" +
+		`{"id":2,"chan` + "\n" +
+		`{"id":3,"channel":"channel","message":"third"}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(offlineClient(), path, Options{}); err != ERROR_OUTBOX_CORRUPTED {
+		t.Fatal("Corrupted log is opened:", err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != log {
+		t.Fatal("Corrupted log is changed:", string(data))
+	}
+}
+
+func TestOutbox_WriteError(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	box, err := Open(offlineClient(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	// Neither writes nor truncates succeed on the read-only file
+	box.mutex.Lock()
+	box.file.Close()
+	box.file, err = os.Open(path)
+	box.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response := <-box.Publish("channel", "first"); response.Err == nil {
+		t.Fatal("Message is published to the read-only log")
+	}
+	if !box.broken {
+		t.Fatal("Log is not marked as broken")
+	}
+
+	<-box.Publish("channel", "second")
+	if box.Pending() != 1 || box.broken {
+		t.Fatal("Log is not repaired:", box.Pending())
+	}
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != `{"id":1,"channel":"channel","message":"second"}`+"\n" {
+		t.Fatal("Wrong log:", string(data))
+	}
+}
+
+func TestOutbox_InvalidMessage(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	box, err := Open(offlineClient(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer box.Close()
+
+	response := <-box.Publish("channel", make(chan int))
+	if err, ok := response.Err.(rtm.RTMError); !ok {
+		t.Fatal("Wrong error returned:", response.Err)
+	} else if _, ok := err.Reason.(*json.UnsupportedTypeError); !ok {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+	if box.Pending() != 0 {
+		t.Fatal("Invalid message is stored")
+	}
+}
@@ -0,0 +1,795 @@
+package pdu
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+
+	cborFalse   = 0xf4
+	cborTrue    = 0xf5
+	cborNull    = 0xf6
+	cborFloat64 = 0xfb
+	cborBreak   = 0xff
+
+	cborIndefinite = 31
+	cborMaxDepth   = 1000
+)
+
+var (
+	ERROR_CBOR_TRUNCATED = errors.New("CBOR: unexpected end of data")
+	ERROR_CBOR_MALFORMED = errors.New("CBOR: malformed data")
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// Encodes the value to CBOR. Structs, maps with string keys, slices and basic values are encoded natively,
+// []byte values become CBOR byte strings. Values that rely on encoding/json features, e.g. MarshalJSON methods,
+// embedded structs or the ",string" option, are encoded with encoding/json and transcoded, so the encoding/json
+// rules apply to them exactly
+func marshalCBOR(v interface{}) ([]byte, error) {
+	e := &cborEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+type cborEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf.WriteByte(cborNull)
+		return nil
+	}
+
+	t := v.Type()
+	switch {
+	case t == jsonNumberType:
+		return e.encodeNumber(json.Number(v.String()))
+	case t == rawMessageType:
+		if v.Len() == 0 {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		return e.encodeJSON(v.Bytes())
+	case !isNativeCBOR(t):
+		return e.encodeWithJSON(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(cborTrue)
+		} else {
+			e.buf.WriteByte(cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeHead(cborUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return e.writeFloat(v.Float())
+	case reflect.String:
+		e.writeString(cborText, v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.writeHead(cborBytes, uint64(v.Len()))
+			e.buf.Write(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	}
+	return nil
+}
+
+// Reports whether values of the type are encoded natively. Types with custom JSON encoding,
+// structs with embedded fields or unsupported options and maps with other keys than strings are left
+// to encoding/json
+func isNativeCBOR(t reflect.Type) bool {
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return false
+	}
+	if t.Kind() != reflect.Interface && t.Kind() != reflect.Ptr {
+		pt := reflect.PtrTo(t)
+		if pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType) {
+			return false
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Slice, reflect.Array, reflect.Ptr, reflect.Interface:
+		return true
+	case reflect.Map:
+		return t.Key().Kind() == reflect.String
+	case reflect.Struct:
+		return cachedStructFields(t) != nil
+	}
+	return false
+}
+
+// Encodes the value with encoding/json and transcodes the result. Addressable values are passed
+// by pointer, so MarshalJSON methods with a pointer receiver are called as encoding/json does
+func (e *cborEncoder) encodeWithJSON(v reflect.Value) error {
+	value := v.Interface()
+	if v.CanAddr() {
+		value = v.Addr().Interface()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return e.encodeJSON(data)
+}
+
+func (e *cborEncoder) encodeArray(v reflect.Value) error {
+	e.writeHead(cborArray, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *cborEncoder) encodeMap(v reflect.Value) error {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	e.writeHead(cborMap, uint64(len(keys)))
+	for _, key := range keys {
+		e.writeString(cborText, key.String())
+		if err := e.encode(v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *cborEncoder) encodeStruct(v reflect.Value) error {
+	fields := cachedStructFields(v.Type())
+
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		fv := v.Field(field.index)
+		if field.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		names = append(names, field.name)
+		values = append(values, fv)
+	}
+
+	e.writeHead(cborMap, uint64(len(values)))
+	for i, fv := range values {
+		e.writeString(cborText, names[i])
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transcodes JSON to CBOR. Integer numbers are encoded as CBOR integers
+func (e *cborEncoder) encodeJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid JSON: %s", string(data))
+	}
+	return e.encode(reflect.ValueOf(value))
+}
+
+func (e *cborEncoder) encodeNumber(n json.Number) error {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		e.writeInt(i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		e.writeHead(cborUint, u)
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return err
+	}
+	return e.writeFloat(f)
+}
+
+func (e *cborEncoder) writeHead(major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		e.buf.WriteByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		e.buf.Write([]byte{major | 24, byte(arg)})
+	case arg <= math.MaxUint16:
+		var b [3]byte
+		b[0] = major | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(arg))
+		e.buf.Write(b[:])
+	case arg <= math.MaxUint32:
+		var b [5]byte
+		b[0] = major | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(arg))
+		e.buf.Write(b[:])
+	default:
+		var b [9]byte
+		b[0] = major | 27
+		binary.BigEndian.PutUint64(b[1:], arg)
+		e.buf.Write(b[:])
+	}
+}
+
+func (e *cborEncoder) writeInt(i int64) {
+	if i < 0 {
+		e.writeHead(cborNegint, uint64(-1-i))
+	} else {
+		e.writeHead(cborUint, uint64(i))
+	}
+}
+
+func (e *cborEncoder) writeFloat(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, 64)}
+	}
+	var b [9]byte
+	b[0] = cborFloat64
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(f))
+	e.buf.Write(b[:])
+	return nil
+}
+
+func (e *cborEncoder) writeString(major byte, s string) {
+	e.writeHead(major, uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+type cborField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var structFieldsCache sync.Map
+
+// Gets the struct fields to encode using the json tags. Returns nil if the struct uses
+// the encoding/json features that are not supported natively, e.g. embedded structs
+func cachedStructFields(t reflect.Type) []cborField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]cborField)
+	}
+	fields := structFields(t)
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func structFields(t reflect.Type) []cborField {
+	fields := []cborField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous {
+			return nil
+		}
+		tag := sf.Tag.Get("json")
+		if sf.PkgPath != "" || tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma+1:]
+		}
+		omitEmpty := false
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "", "RawMessage":
+			case "omitempty":
+				omitEmpty = true
+			default:
+				return nil
+			}
+		}
+
+		if !isValidTag(name) {
+			name = ""
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, cborField{
+			name:      name,
+			index:     i,
+			omitEmpty: omitEmpty,
+		})
+	}
+	return fields
+}
+
+// Reports whether encoding/json accepts the name from the json tag
+func isValidTag(name string) bool {
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Transcodes a single CBOR data item to JSON. Byte strings are converted to base64 strings,
+// map keys that are not text strings are converted to strings, tags are ignored.
+func cborToJSON(data []byte) (json.RawMessage, error) {
+	d := &cborDecoder{data: data}
+	var out bytes.Buffer
+	if err := d.transcode(&out, 0); err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, ERROR_CBOR_MALFORMED
+	}
+	return out.Bytes(), nil
+}
+
+// Decodes the PDU. Action and id are decoded natively, the body is transcoded to JSON:
+// the SDK and the applications read PDU bodies with encoding/json
+func unmarshalCBORQuery(data []byte, query *RTMQuery) error {
+	d := &cborDecoder{data: data}
+	major, info, length, err := d.readHead()
+	if err != nil {
+		return err
+	}
+	if major != cborMap {
+		return ERROR_INVALID_PDU
+	}
+
+	*query = RTMQuery{}
+	for i := uint64(0); info == cborIndefinite || i < length; i++ {
+		if info == cborIndefinite && d.readBreak() {
+			break
+		}
+		key, ok, err := d.readText()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := d.skip(1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch string(key) {
+		case "action":
+			action, _, err := d.readText()
+			if err != nil {
+				return err
+			}
+			query.Action = string(action)
+		case "id":
+			if query.Id, err = d.readId(); err != nil {
+				return err
+			}
+		case "body":
+			var body bytes.Buffer
+			if err := d.transcode(&body, 1); err != nil {
+				return err
+			}
+			query.Body = body.Bytes()
+		default:
+			if err := d.skip(1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.pos != len(d.data) {
+		return ERROR_CBOR_MALFORMED
+	}
+	return nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) transcode(out *bytes.Buffer, depth int) error {
+	if depth > cborMaxDepth {
+		return ERROR_CBOR_MALFORMED
+	}
+
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case cborUint:
+		out.WriteString(strconv.FormatUint(arg, 10))
+	case cborNegint:
+		out.WriteByte('-')
+		if arg == math.MaxUint64 {
+			out.WriteString("18446744073709551616")
+		} else {
+			out.WriteString(strconv.FormatUint(arg+1, 10))
+		}
+	case cborBytes:
+		b, err := d.readString(cborBytes, info, arg)
+		if err != nil {
+			return err
+		}
+		out.WriteByte('"')
+		out.WriteString(base64.StdEncoding.EncodeToString(b))
+		out.WriteByte('"')
+	case cborText:
+		b, err := d.readString(cborText, info, arg)
+		if err != nil {
+			return err
+		}
+		writeJSONString(out, b)
+	case cborArray:
+		out.WriteByte('[')
+		for i := uint64(0); info == cborIndefinite || i < arg; i++ {
+			if info == cborIndefinite && d.readBreak() {
+				break
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := d.transcode(out, depth+1); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case cborMap:
+		out.WriteByte('{')
+		for i := uint64(0); info == cborIndefinite || i < arg; i++ {
+			if info == cborIndefinite && d.readBreak() {
+				break
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := d.transcodeKey(out, depth+1); err != nil {
+				return err
+			}
+			out.WriteByte(':')
+			if err := d.transcode(out, depth+1); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	case cborTag:
+		return d.transcode(out, depth+1)
+	default:
+		return d.transcodeSimple(out, info, arg)
+	}
+	return nil
+}
+
+// JSON object keys must be strings. Other keys are transcoded to JSON and quoted
+func (d *cborDecoder) transcodeKey(out *bytes.Buffer, depth int) error {
+	if d.pos < len(d.data) && d.data[d.pos]>>5 == cborText {
+		return d.transcode(out, depth)
+	}
+	var key bytes.Buffer
+	if err := d.transcode(&key, depth); err != nil {
+		return err
+	}
+	writeJSONString(out, key.Bytes())
+	return nil
+}
+
+func (d *cborDecoder) transcodeSimple(out *bytes.Buffer, info byte, arg uint64) error {
+	switch info {
+	case 20:
+		out.WriteString("false")
+	case 21:
+		out.WriteString("true")
+	case 22, 23:
+		out.WriteString("null")
+	case 25:
+		return writeJSONFloat(out, float16to64(uint16(arg)), 32)
+	case 26:
+		return writeJSONFloat(out, float64(math.Float32frombits(uint32(arg))), 32)
+	case 27:
+		return writeJSONFloat(out, math.Float64frombits(arg), 64)
+	default:
+		return ERROR_CBOR_MALFORMED
+	}
+	return nil
+}
+
+// Reads the initial byte and the argument of a data item
+func (d *cborDecoder) readHead() (major, info byte, arg uint64, err error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, 0, ERROR_CBOR_TRUNCATED
+	}
+	b := d.data[d.pos]
+	d.pos++
+	major, info = b>>5, b&0x1f
+
+	size := 0
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == cborIndefinite && major >= cborBytes && major <= cborMap:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, ERROR_CBOR_MALFORMED
+	}
+
+	if len(d.data)-d.pos < size {
+		return 0, 0, 0, ERROR_CBOR_TRUNCATED
+	}
+	for _, b := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.pos += size
+	return major, info, arg, nil
+}
+
+// Reads a string of the major type. The result shares memory with the data for definite-length strings
+func (d *cborDecoder) readString(major, info byte, length uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		if uint64(len(d.data)-d.pos) < length {
+			return nil, ERROR_CBOR_TRUNCATED
+		}
+		b := d.data[d.pos : d.pos+int(length)]
+		d.pos += int(length)
+		return b, nil
+	}
+
+	// Indefinite-length string is a sequence of definite-length chunks of the same major type
+	b := []byte{}
+	for !d.readBreak() {
+		chunkMajor, chunkInfo, chunkLength, err := d.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, ERROR_CBOR_MALFORMED
+		}
+		chunk, err := d.readString(major, chunkInfo, chunkLength)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+	return b, nil
+}
+
+// Reads a text string. Skips the data item and returns false if it is not a text string
+func (d *cborDecoder) readText() ([]byte, bool, error) {
+	if d.pos < len(d.data) && d.data[d.pos]>>5 != cborText {
+		return nil, false, d.skip(1)
+	}
+	_, info, length, err := d.readHead()
+	if err != nil {
+		return nil, false, err
+	}
+	text, err := d.readString(cborText, info, length)
+	return text, err == nil, err
+}
+
+// Reads the PDU id. Numeric ids are converted to strings, ids of other types are skipped
+func (d *cborDecoder) readId() (string, error) {
+	if d.pos < len(d.data) && d.data[d.pos]>>5 == cborUint {
+		_, _, id, err := d.readHead()
+		return strconv.FormatUint(id, 10), err
+	}
+	id, _, err := d.readText()
+	return string(id), err
+}
+
+// Skips a data item without decoding it
+func (d *cborDecoder) skip(depth int) error {
+	if depth > cborMaxDepth {
+		return ERROR_CBOR_MALFORMED
+	}
+
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case cborUint, cborNegint:
+	case cborBytes, cborText:
+		_, err = d.readString(major, info, arg)
+	case cborArray, cborMap:
+		for i := uint64(0); err == nil && (info == cborIndefinite || i < arg); i++ {
+			if info == cborIndefinite && d.readBreak() {
+				break
+			}
+			err = d.skip(depth + 1)
+			if err == nil && major == cborMap {
+				err = d.skip(depth + 1)
+			}
+		}
+	case cborTag:
+		err = d.skip(depth + 1)
+	default:
+		switch info {
+		case 20, 21, 22, 23, 25, 26, 27:
+		default:
+			err = ERROR_CBOR_MALFORMED
+		}
+	}
+	return err
+}
+
+// Consumes the "break" stop code if it is the next byte
+func (d *cborDecoder) readBreak() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == cborBreak {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func writeJSONString(out *bytes.Buffer, s []byte) {
+	if !utf8.Valid(s) {
+		s = bytes.ToValidUTF8(s, []byte("�"))
+	}
+
+	out.WriteByte('"')
+	start := 0
+	for i, c := range s {
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		out.Write(s[start:i])
+		switch c {
+		case '"', '\\':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			out.WriteString(`\u00`)
+			out.WriteByte(hexDigits[c>>4])
+			out.WriteByte(hexDigits[c&0xf])
+		}
+		start = i + 1
+	}
+	out.Write(s[start:])
+	out.WriteByte('"')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// Formats the float like encoding/json does
+func writeJSONFloat(out *bytes.Buffer, f float64, bits int) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, bits)}
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b := strconv.AppendFloat(nil, f, format, -1, bits)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	out.Write(b)
+	return nil
+}
+
+func float16to64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1.0
+	}
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(mant+1024, exp-25)
+}
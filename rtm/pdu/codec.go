@@ -0,0 +1,73 @@
+package pdu
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ERROR_INVALID_PDU = errors.New("Invalid PDU")
+)
+
+// Encodes and decodes PDUs for the wire.
+//
+// The PDU body is always exposed as JSON (RTMQuery.Body, SubscriptionData.Messages, etc.), so codecs
+// only change the representation used on the wire.
+type Codec interface {
+	// WebSocket subprotocol negotiated with the endpoint. Empty string means no subprotocol
+	Subprotocol() string
+
+	// Reports whether PDUs are sent in binary WebSocket frames instead of text frames
+	Binary() bool
+
+	// Encodes a PDU. The value is marshaled according to the encoding/json rules:
+	// json tags, json.Marshaler and json.RawMessage are supported.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Decodes a PDU received from the endpoint
+	Unmarshal(data []byte, query *RTMQuery) error
+}
+
+// Default codec. Sends PDUs as JSON in text frames
+type JSONCodec struct{}
+
+func (c JSONCodec) Subprotocol() string {
+	return ""
+}
+
+func (c JSONCodec) Binary() bool {
+	return false
+}
+
+func (c JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c JSONCodec) Unmarshal(data []byte, query *RTMQuery) error {
+	return json.Unmarshal(data, query)
+}
+
+// Sends PDUs as CBOR (RFC 7049) in binary frames using the "cbor" subprotocol.
+//
+// CBOR is more compact than JSON and supports binary data natively: []byte values, including struct fields,
+// are sent as CBOR byte strings instead of base64 strings. Types with MarshalJSON or MarshalText and structs
+// with embedded fields or tag options like ",string" are encoded with encoding/json.
+// Byte strings received from the endpoint are exposed as base64 JSON strings, so they can be unmarshaled
+// to []byte fields with json.Unmarshal.
+type CBORCodec struct{}
+
+func (c CBORCodec) Subprotocol() string {
+	return "cbor"
+}
+
+func (c CBORCodec) Binary() bool {
+	return true
+}
+
+func (c CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	return marshalCBOR(v)
+}
+
+func (c CBORCodec) Unmarshal(data []byte, query *RTMQuery) error {
+	return unmarshalCBORQuery(data, query)
+}
@@ -0,0 +1,78 @@
+package pdu
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Sentinels for the error codes of RTM error responses. ServerError wraps the sentinel
+// of its code, so it can be checked with errors.Is:
+//
+//   if errors.Is(response.Err, pdu.ERROR_AUTHORIZATION_DENIED) {
+//     // The role is not allowed to use the channel
+//   }
+var (
+	ERROR_AUTHENTICATION_FAILED = errors.New("Authentication failed")
+	ERROR_AUTHORIZATION_DENIED  = errors.New("Authorization denied")
+	ERROR_INVALID_FORMAT        = errors.New("Invalid PDU format")
+	ERROR_JSON_PARSE_ERROR      = errors.New("Unable to parse JSON")
+	ERROR_INVALID_SERVICE       = errors.New("Invalid service")
+	ERROR_EXPIRED_POSITION      = errors.New("Position is expired")
+	ERROR_OUT_OF_SYNC           = errors.New("Subscription is out of sync")
+	ERROR_ALREADY_SUBSCRIBED    = errors.New("Subscription already exists")
+	ERROR_NOT_SUBSCRIBED        = errors.New("Subscription not found")
+)
+
+var serverErrorCodes = map[string]error{
+	"authentication_failed": ERROR_AUTHENTICATION_FAILED,
+	"authorization_denied":  ERROR_AUTHORIZATION_DENIED,
+	"invalid_format":        ERROR_INVALID_FORMAT,
+	"json_parse_error":      ERROR_JSON_PARSE_ERROR,
+	"invalid_service":       ERROR_INVALID_SERVICE,
+	"expired_position":      ERROR_EXPIRED_POSITION,
+	"out_of_sync":           ERROR_OUT_OF_SYNC,
+	"already_subscribed":    ERROR_ALREADY_SUBSCRIBED,
+	"not_subscribed":        ERROR_NOT_SUBSCRIBED,
+}
+
+// Error response from RTM, e.g. "rtm/publish/error"
+type ServerError struct {
+	// Action of the response PDU
+	Action string `json:"-"`
+
+	// Error code, e.g. "authorization_denied". Empty if the error body cannot be parsed
+	Code string `json:"error"`
+
+	// Human-readable description of the error. Contains the whole error body if it cannot be parsed
+	Reason string `json:"reason"`
+
+	// Current position of the channel. Set for expired_position and out_of_sync errors
+	Position string `json:"position,omitempty"`
+}
+
+// Returns the error body as JSON, e.g. {"error":"authorization_denied","reason":"Unauthorized"}
+func (e ServerError) Error() string {
+	if len(e.Code) == 0 {
+		return e.Reason
+	}
+	message, _ := json.Marshal(e)
+	return string(message)
+}
+
+// Returns the sentinel of the error code, so errors.Is matches it. Returns nil if the code is unknown
+func (e ServerError) Unwrap() error {
+	return serverErrorCodes[e.Code]
+}
+
+func newServerError(response RTMQuery) ServerError {
+	serverErr := ServerError{
+		Action: response.Action,
+	}
+	if err := json.Unmarshal(response.Body, &serverErr); err != nil || len(serverErr.Code) == 0 {
+		serverErr = ServerError{
+			Action: response.Action,
+			Reason: string(response.Body),
+		}
+	}
+	return serverErr
+}
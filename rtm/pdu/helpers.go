@@ -2,7 +2,6 @@ package pdu
 
 import (
 	"encoding/json"
-	"fmt"
 	"strings"
 )
 
@@ -22,11 +21,11 @@ func GetResponseCode(query RTMQuery) int {
 	}
 }
 
-// Gets error as type "error" from PDU
+// Gets error as type "error" from PDU. Returns ServerError for error responses and nil otherwise
 func GetResponseError(response RTMQuery) error {
 	responseCode := GetResponseCode(response)
 	if responseCode == CODE_ERROR_REQUEST {
-		return fmt.Errorf(string(response.Body))
+		return newServerError(response)
 	}
 
 	return nil
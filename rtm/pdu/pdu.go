@@ -11,6 +11,9 @@ const (
 	CODE_ERROR_REQUEST = 1
 )
 
+// Value of the "only" subscribe modifier. Check SubscribeBodyOpts.Only
+const ONLY_VALUE = "value"
+
 type RTMQuery struct {
 	Action string          `json:"action"`
 	Body   json.RawMessage `json:"body,RawMessage"`
@@ -18,8 +21,21 @@ type RTMQuery struct {
 }
 
 type PublishBody struct {
-	Channel string      `json:"channel"`
-	Message interface{} `json:"message"`
+	Channel    string      `json:"channel"`
+	Message    interface{} `json:"message"`
+	Ttl        int         `json:"ttl,omitempty"`
+	TtlMessage interface{} `json:"ttl_message,omitempty"`
+}
+
+// Optional parameters of rtm/publish
+type PublishBodyOpts struct {
+	// Time to live of the message in seconds. If no other message is published to the channel
+	// within Ttl seconds, RTM publishes TtlMessage to the channel, e.g. to announce that the device
+	// publishing its state went offline. Zero means no TTL.
+	Ttl int `json:"ttl,omitempty"`
+
+	// Message that replaces the published one when its TTL expires. Requires Ttl
+	TtlMessage interface{} `json:"ttl_message,omitempty"`
 }
 
 type PublishBodyResponse struct {
@@ -47,12 +63,28 @@ type ReadBodyResponse struct {
 
 type DeleteBody struct {
 	Channel string `json:"channel"`
+	Purge   bool   `json:"purge,omitempty"`
+}
+
+// Optional parameters of rtm/delete
+type DeleteBodyOpts struct {
+	// Deletes the whole channel history instead of writing null as the channel value
+	Purge bool `json:"purge,omitempty"`
 }
 
 type DeleteBodyResponse struct {
 	Position string `json:"position"`
 }
 
+type SearchBody struct {
+	Prefix string `json:"prefix"`
+}
+
+// Body of rtm/search/data and rtm/search/ok responses. Every response carries a part of the found channels
+type SearchBodyResponse struct {
+	Channels []string `json:"channels"`
+}
+
 type SubscribeBody struct {
 	Channel        string           `json:"channel,omitempty"`
 	Force          bool             `json:"force,omitempty"`
@@ -62,6 +94,7 @@ type SubscribeBody struct {
 	History        SubscribeHistory `json:"history,omitempty"`
 	Period         int              `json:"period,omitempty"`
 	Position       string           `json:"position,omitempty"`
+	Only           string           `json:"only,omitempty"`
 }
 
 type SubscribeBodyOpts struct {
@@ -69,6 +102,11 @@ type SubscribeBodyOpts struct {
 	History  SubscribeHistory `json:"history,omitempty"`
 	Period   int              `json:"period,omitempty"`
 	Position string           `json:"position"`
+
+	// Set to ONLY_VALUE to receive only the latest value of a key-value channel: the current value
+	// right after subscribing and then every new value. Messages the subscription cannot keep up with
+	// are skipped instead of failing the subscription with out_of_sync.
+	Only string `json:"only,omitempty"`
 }
 
 type SubscribeHistory struct {
@@ -1,9 +1,14 @@
 package pdu
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetResponseCode(t *testing.T) {
@@ -57,6 +62,128 @@ func TestGetResponseError(t *testing.T) {
 	}
 }
 
+func TestServerError(t *testing.T) {
+	query := RTMQuery{
+		Action: "rtm/read/error",
+		Body:   json.RawMessage(`{"error":"expired_position","reason":"Position is too old","position":"1479315802:0"}`),
+	}
+	err := GetResponseError(query)
+	serverErr, ok := err.(ServerError)
+	if !ok {
+		t.Fatal("Error response is not ServerError:", err)
+	}
+	if serverErr.Action != "rtm/read/error" || serverErr.Code != "expired_position" ||
+		serverErr.Reason != "Position is too old" || serverErr.Position != "1479315802:0" {
+		t.Fatal("Wrong error fields:", serverErr)
+	}
+	if err.Error() != string(query.Body) {
+		t.Fatal("Wrong error message:", err.Error())
+	}
+	if !errors.Is(err, ERROR_EXPIRED_POSITION) || errors.Is(err, ERROR_OUT_OF_SYNC) {
+		t.Fatal("Error does not match the sentinel of its code")
+	}
+
+	query.Body = json.RawMessage(`{"error":"some_new_error","reason":"Something happened"}`)
+	err = GetResponseError(query)
+	if err.(ServerError).Code != "some_new_error" || errors.Unwrap(err) != nil {
+		t.Fatal("Unknown error code is not preserved:", err)
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	position, err := ParsePosition("1492522119:42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Seconds != 1492522119 || position.Sequence != 42 {
+		t.Fatal("Wrong position parsed:", position)
+	}
+	if position.String() != "1492522119:42" {
+		t.Fatal("Wrong position string:", position.String())
+	}
+	if !position.Time().Equal(time.Unix(1492522119, 0)) {
+		t.Fatal("Wrong position time:", position.Time())
+	}
+
+	for _, invalid := range []string{"", "1492522119", "1492522119:", ":42", "a:1", "1:b", "1:2:3", "-1:0", "1:-2"} {
+		if _, err := ParsePosition(invalid); err != ERROR_INVALID_POSITION {
+			t.Fatal("Invalid position is parsed:", invalid)
+		}
+	}
+}
+
+func TestPosition_Compare(t *testing.T) {
+	ordered := []string{"1492522118:100", "1492522119:0", "1492522119:9", "1492522119:10", "1492522120:0"}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParsePosition(ordered[i])
+			b, _ := ParsePosition(ordered[j])
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if a.Compare(b) != expected || a.Before(b) != (i < j) || a.After(b) != (i > j) {
+				t.Fatal("Wrong order of", ordered[i], "and", ordered[j])
+			}
+		}
+	}
+}
+
+func TestPosition_JSON(t *testing.T) {
+	var response struct {
+		Position Position `json:"position"`
+		Previous Position `json:"previous"`
+	}
+	if err := json.Unmarshal([]byte(`{"position":"1492522119:7","previous":""}`), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Position.Compare(NewPosition(1492522119, 7)) != 0 || !response.Previous.IsZero() {
+		t.Fatal("Wrong positions decoded:", response)
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"position":"1492522119:7","previous":""}` {
+		t.Fatal("Wrong positions encoded:", string(data))
+	}
+
+	if err := json.Unmarshal([]byte(`{"position":"broken"}`), &response); err != ERROR_INVALID_POSITION {
+		t.Fatal("Invalid position is decoded:", err)
+	}
+
+	// "0:0" is a valid position, not the zero Position
+	if err := json.Unmarshal([]byte(`{"position":"0:0","previous":"1:2"}`), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Position.IsZero() {
+		t.Fatal("0:0 position is decoded as zero")
+	}
+	response.Previous = Position{Seconds: 1}
+	data, _ = json.Marshal(response)
+	if string(data) != `{"position":"0:0","previous":"1:0"}` {
+		t.Fatal("Wrong positions encoded:", string(data))
+	}
+	if data, _ := json.Marshal(NewPosition(0, 0)); string(data) != `"0:0"` {
+		t.Fatal("Wrong position encoded:", string(data))
+	}
+}
+
+func TestPublishBody_TTL(t *testing.T) {
+	data, _ := json.Marshal(PublishBody{Channel: "ch", Message: 1})
+	if string(data) != `{"channel":"ch","message":1}` {
+		t.Fatal("Empty TTL fields are sent:", string(data))
+	}
+
+	data, _ = json.Marshal(PublishBody{Channel: "ch", Message: 1, Ttl: 30, TtlMessage: "offline"})
+	if string(data) != `{"channel":"ch","message":1,"ttl":30,"ttl_message":"offline"}` {
+		t.Fatal("Wrong TTL fields:", string(data))
+	}
+}
+
 func TestRTMQuery_String(t *testing.T) {
 	query := RTMQuery{
 		Action: "rtm/publish/ok",
@@ -67,3 +194,337 @@ func TestRTMQuery_String(t *testing.T) {
 		t.Fatal(query)
 	}
 }
+
+func TestCBOREncoding(t *testing.T) {
+	// Test vectors from RFC 7049, Appendix A
+	cases := []struct {
+		value interface{}
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{uint64(18446744073709551615), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.5, "fb3ff8000000000000"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"", "60"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]int{1, 2, 3}, "83010203"},
+		{map[string]int{"a": 1, "b": 2}, "a26161016162 02"},
+		{json.RawMessage(`{"a":[1,-1,1.5]}`), "a1616183 0120fb3ff8000000000000"},
+		{json.Number("1000"), "1903e8"},
+	}
+
+	for _, c := range cases {
+		data, err := CBORCodec{}.Marshal(c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(data) != strings.Replace(c.hex, " ", "", -1) {
+			t.Errorf("Wrong encoding of %#v: %x", c.value, data)
+		}
+	}
+}
+
+type cborPointerMarshaler struct {
+	Value string
+}
+
+func (m *cborPointerMarshaler) MarshalJSON() ([]byte, error) {
+	return json.Marshal("pointer:" + m.Value)
+}
+
+func TestCBORStructFields(t *testing.T) {
+	type Embedded struct {
+		Inner  string `json:"inner"`
+		Shadow string `json:"shadow"`
+	}
+	type Other struct {
+		Inner string `json:"inner"`
+	}
+	type Value struct {
+		Embedded
+		*Other
+		Name      string                 `json:"name"`
+		Shadow    string                 `json:"shadow"`
+		Empty     string                 `json:"empty,omitempty"`
+		Ignored   string                 `json:"-"`
+		Untagged  int                    `json:",omitempty"`
+		Quoted    int64                  `json:"quoted,string"`
+		Payload   []byte                 `json:"payload"`
+		Message   interface{}            `json:"message"`
+		Marshaler cborPointerMarshaler   `json:"marshaler"`
+		List      []cborPointerMarshaler `json:"list"`
+		Keys      map[int]string         `json:"keys"`
+		Position  Position               `json:"position"`
+		private   string
+	}
+
+	value := Value{
+		Embedded:  Embedded{"inner", "hidden"},
+		Other:     &Other{"ambiguous"},
+		Name:      "name",
+		Shadow:    "outer",
+		Ignored:   "ignored",
+		Untagged:  1,
+		Quoted:    42,
+		Payload:   []byte{0xff},
+		Message:   json.RawMessage(`{"a":1}`),
+		Marshaler: cborPointerMarshaler{"field"},
+		List:      []cborPointerMarshaler{{"element"}},
+		Keys:      map[int]string{1: "one"},
+		Position:  NewPosition(1, 2),
+		private:   "private",
+	}
+	assertCBORMatchesJSON(t, value)
+	assertCBORMatchesJSON(t, &value)
+	assertCBORMatchesJSON(t, []Value{value})
+
+	if _, err := (CBORCodec{}).Marshal(func() {}); err == nil {
+		t.Fatal("Function was encoded")
+	}
+	if _, err := (CBORCodec{}).Marshal(json.RawMessage(`{"a":`)); err == nil {
+		t.Fatal("Invalid JSON was encoded")
+	}
+}
+
+func TestCBORMatchesJSON(t *testing.T) {
+	message := map[string]interface{}{"text": "hello", "list": []interface{}{1, -1.5, nil, true}}
+	values := []interface{}{
+		RTMQuery{Action: "rtm/publish", Body: json.RawMessage(`{"channel":"channel"}`), Id: "1"},
+		RTMQuery{Action: "rtm/publish"},
+		PublishBody{Channel: "channel", Message: message, Ttl: 10, TtlMessage: "gone"},
+		PublishBody{Channel: "channel", Message: nil},
+		PublishBodyOpts{Ttl: 1},
+		PublishBodyResponse{Position: "1:2"},
+		WriteBody{Channel: "channel", Message: []int{1, 2}},
+		WriteBodyResponse{Position: "1:2"},
+		ReadBody{Channel: "channel", Position: "1:2"},
+		ReadBodyResponse{Message: json.RawMessage(`"message"`), Position: "1:2"},
+		DeleteBody{Channel: "channel", Purge: true},
+		DeleteBodyOpts{},
+		DeleteBodyResponse{Position: "1:2"},
+		SearchBody{Prefix: "prefix"},
+		SearchBodyResponse{Channels: []string{"a", "b"}},
+		SearchBodyResponse{},
+		SubscribeBody{Channel: "channel", Force: true, FastForward: true, Filter: "select * from `a`", History: SubscribeHistory{Count: 1, Age: 2}, Period: 1, Position: "1:2", Only: ONLY_VALUE},
+		SubscribeBody{SubscriptionId: "id"},
+		SubscribeBodyOpts{},
+		SubscribeOk{Position: "1:2", SubscriptionId: "id"},
+		SubscribeError{Error: "error", Reason: "reason", SubscriptionId: "id"},
+		SubscriptionInfo{Info: "info", Reason: "reason", SubscriptionId: "id", Position: "1:2"},
+		SubscriptionError{Error: "error", Reason: "reason", Position: "1:2", SubscriptionId: "id"},
+		SubscriptionData{Position: "1:2", Messages: []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`{"a":[]}`)}, SubscriptionId: "id"},
+		UnsubscribeBody{SubscriptionId: "id"},
+		UnsubscribeBodyResponse{Position: "1:2", SubscriptionId: "id"},
+		UnsubscribeError{Error: "error", Reason: "reason", SubscriptionId: "id"},
+		Error{Error: "error", Reason: "reason"},
+		NewPosition(1, 2),
+		&Position{},
+		time.Unix(1, 0).UTC(),
+		json.Number("1.5"),
+		map[string][]byte{"payload": {1, 2, 3}},
+		[2]uint8{1, 2},
+	}
+	for _, value := range values {
+		assertCBORMatchesJSON(t, value)
+	}
+}
+
+// Checks that the value transcoded from CBOR to JSON is the same as encoded with encoding/json
+func assertCBORMatchesJSON(t *testing.T, value interface{}) {
+	t.Helper()
+	data, err := CBORCodec{}.Marshal(value)
+	if err != nil {
+		t.Fatalf("%T: %v", value, err)
+	}
+	actual, err := cborToJSON(data)
+	if err != nil {
+		t.Fatalf("%T: %v", value, err)
+	}
+	expected, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("%T: %v", value, err)
+	}
+
+	var actualValue, expectedValue interface{}
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(expected, &expectedValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actualValue, expectedValue) {
+		t.Fatalf("%T is encoded as %s, encoding/json gives %s", value, actual, expected)
+	}
+}
+
+func TestCBORDecoding(t *testing.T) {
+	cases := []struct {
+		hex  string
+		json string
+	}{
+		{"f93c00", "1"},
+		{"f9c400", "-4"},
+		{"fa47c35000", "100000"},
+		{"3bffffffffffffffff", "-18446744073709551616"},
+		{"fb3e112e0be826d695", "1e-9"},
+		{"6a225c0a01c3a92f3c3e26", `"\"\\\n\u0001é/<>&"`},
+		{"9f018202039f0405ffff", "[1,[2,3],[4,5]]"},
+		{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+		{"7f657374726561646d696e67ff", `"streaming"`},
+		{"5f42010243030405ff", `"AQIDBAU="`},
+		{"c11a514b67b0", "1363896240"},
+		{"a201020304", `{"1":2,"3":4}`},
+		{"a1f5f4", `{"true":false}`},
+		{"f7", "null"},
+		{"80", "[]"},
+	}
+
+	for _, c := range cases {
+		data, _ := hex.DecodeString(c.hex)
+		actual, err := cborToJSON(data)
+		if err != nil {
+			t.Fatal(c.hex, err)
+		}
+		if string(actual) != c.json {
+			t.Errorf("Wrong decoding of %s: %s", c.hex, actual)
+		}
+	}
+
+	for _, malformed := range []string{"", "18", "62ff", "1c", "9f01", "0000", "ff", "5f6161ff"} {
+		data, _ := hex.DecodeString(malformed)
+		if _, err := cborToJSON(data); err == nil {
+			t.Error("Malformed CBOR was decoded:", malformed)
+		}
+	}
+}
+
+func TestCBORQueryDecoding(t *testing.T) {
+	cases := []struct {
+		hex   string
+		query RTMQuery
+	}{
+		// Numeric id, unknown keys and non-text keys are skipped
+		{"a566616374696f6e63612f62626964076565787472618201a161784100010264626f6479a1616b01", RTMQuery{Action: "a/b", Id: "7", Body: json.RawMessage(`{"k":1}`)}},
+		// Indefinite-length map
+		{"bf626964613966616374696f6e6178ff", RTMQuery{Action: "x", Id: "9"}},
+	}
+	for _, c := range cases {
+		data, _ := hex.DecodeString(c.hex)
+		var query RTMQuery
+		if err := (CBORCodec{}).Unmarshal(data, &query); err != nil {
+			t.Fatal(c.hex, err)
+		}
+		if query.Action != c.query.Action || query.Id != c.query.Id || string(query.Body) != string(c.query.Body) {
+			t.Errorf("Wrong decoding of %s: %s", c.hex, query.String())
+		}
+	}
+
+	for _, malformed := range []string{"a1", "a16269", "a1626964", "bf626964", "a16565787472619f01", "a1656578747261fc"} {
+		data, _ := hex.DecodeString(malformed)
+		var query RTMQuery
+		if err := (CBORCodec{}).Unmarshal(data, &query); err == nil {
+			t.Error("Malformed PDU was decoded:", malformed)
+		}
+	}
+}
+
+func TestCBORNativeStruct(t *testing.T) {
+	type Frame struct {
+		Name    string `json:"name"`
+		Payload []byte `json:"payload,omitempty"`
+		Ignored int    `json:"-"`
+	}
+
+	value := Frame{Name: "a", Payload: []byte{1}, Ignored: 1}
+	data, err := CBORCodec{}.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(data) != "a2646e616d656161677061796c6f61644101" {
+		t.Fatal("Struct is not encoded natively:", hex.EncodeToString(data))
+	}
+	assertCBORMatchesJSON(t, value)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	body := PublishBody{
+		Channel: "channel",
+		Message: map[string]interface{}{"text": "hello", "count": 42, "list": []float64{1.5, -2}},
+	}
+
+	for _, codec := range []Codec{JSONCodec{}, CBORCodec{}} {
+		data, err := codec.Marshal(map[string]interface{}{
+			"action": "rtm/publish",
+			"body":   body,
+			"id":     "42",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var query RTMQuery
+		if err := codec.Unmarshal(data, &query); err != nil {
+			t.Fatal(err)
+		}
+		if query.Action != "rtm/publish" || query.Id != "42" {
+			t.Fatal("Wrong PDU decoded:", query)
+		}
+		if string(query.Body) != `{"channel":"channel","message":{"count":42,"list":[1.5,-2],"text":"hello"}}` {
+			t.Fatal("Wrong body decoded:", string(query.Body))
+		}
+	}
+
+	var query RTMQuery
+	if err := (CBORCodec{}).Unmarshal([]byte{0x01}, &query); err != ERROR_INVALID_PDU {
+		t.Fatal("Non-map PDU was decoded")
+	}
+}
+
+func benchmarkPDU() interface{} {
+	messages := make([]map[string]interface{}, 20)
+	for i := range messages {
+		messages[i] = map[string]interface{}{"id": i, "name": "sensor", "value": float64(i) * 1.5, "active": true}
+	}
+	return map[string]interface{}{
+		"action": "rtm/subscription/data",
+		"body": map[string]interface{}{
+			"position":        "1479315802:0",
+			"subscription_id": "channel",
+			"messages":        messages,
+		},
+	}
+}
+
+func benchmarkMarshal(b *testing.B, codec Codec) {
+	pdu := benchmarkPDU()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Marshal(pdu); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkUnmarshal(b *testing.B, codec Codec) {
+	data, _ := codec.Marshal(benchmarkPDU())
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var query RTMQuery
+		if err := codec.Unmarshal(data, &query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshal_JSON(b *testing.B)   { benchmarkMarshal(b, JSONCodec{}) }
+func BenchmarkMarshal_CBOR(b *testing.B)   { benchmarkMarshal(b, CBORCodec{}) }
+func BenchmarkUnmarshal_JSON(b *testing.B) { benchmarkUnmarshal(b, JSONCodec{}) }
+func BenchmarkUnmarshal_CBOR(b *testing.B) { benchmarkUnmarshal(b, CBORCodec{}) }
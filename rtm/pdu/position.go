@@ -0,0 +1,129 @@
+package pdu
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ERROR_INVALID_POSITION = errors.New("Invalid position")
+)
+
+// Position of a message in the channel, e.g. "1492522119:0": the time the message was published,
+// in seconds since the Unix epoch, and the sequence number of the message.
+//
+// RTM responses carry positions as strings. Parse them to order, de-duplicate messages or compute the lag:
+//
+//   position, err := pdu.ParsePosition(data.Position)
+//   if err == nil && !position.After(lastSeen) {
+//     // Already processed
+//   }
+//   lag := time.Since(position.Time())
+//
+// Position is marshaled to JSON as the string, so it can be used in structs that decode PDU bodies.
+// The zero Position, that was neither parsed nor created with NewPosition, is marshaled as an empty string.
+// Use Compare instead of == to check if two positions are equal.
+type Position struct {
+	Seconds  int64
+	Sequence uint64
+
+	// Distinguishes the parsed "0:0" position from the zero Position
+	valid bool
+}
+
+// Creates the position. Unlike the Position literal, the result is not zero even for "0:0"
+func NewPosition(seconds int64, sequence uint64) Position {
+	return Position{
+		Seconds:  seconds,
+		Sequence: sequence,
+		valid:    true,
+	}
+}
+
+// Parses the "<seconds>:<sequence>" position. Returns ERROR_INVALID_POSITION if the string is malformed
+func ParsePosition(position string) (Position, error) {
+	parts := strings.Split(position, ":")
+	if len(parts) != 2 {
+		return Position{}, ERROR_INVALID_POSITION
+	}
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || seconds < 0 {
+		return Position{}, ERROR_INVALID_POSITION
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return Position{}, ERROR_INVALID_POSITION
+	}
+	return NewPosition(seconds, sequence), nil
+}
+
+// Returns the position as RTM represents it, e.g. "1492522119:0"
+func (p Position) String() string {
+	return strconv.FormatInt(p.Seconds, 10) + ":" + strconv.FormatUint(p.Sequence, 10)
+}
+
+// Returns the time the message was published, with one second precision
+func (p Position) Time() time.Time {
+	return time.Unix(p.Seconds, 0)
+}
+
+// Returns -1 if p is before other, +1 if p is after other and 0 if the positions are equal
+func (p Position) Compare(other Position) int {
+	switch {
+	case p.Seconds < other.Seconds:
+		return -1
+	case p.Seconds > other.Seconds:
+		return 1
+	case p.Sequence < other.Sequence:
+		return -1
+	case p.Sequence > other.Sequence:
+		return 1
+	}
+	return 0
+}
+
+func (p Position) Before(other Position) bool {
+	return p.Compare(other) < 0
+}
+
+func (p Position) After(other Position) bool {
+	return p.Compare(other) > 0
+}
+
+// Checks if the position is not set: it was neither parsed nor created with NewPosition, and its fields are zero
+func (p Position) IsZero() bool {
+	return !p.valid && p.Seconds == 0 && p.Sequence == 0
+}
+
+func (p Position) MarshalJSON() ([]byte, error) {
+	if p.IsZero() {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(p.String())
+}
+
+// Accepts the position string. Empty string and null are decoded as the zero Position
+func (p *Position) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*p = Position{}
+		return nil
+	}
+	var position string
+	if err := json.Unmarshal(data, &position); err != nil {
+		return err
+	}
+	if len(position) == 0 {
+		*p = Position{}
+		return nil
+	}
+
+	parsed, err := ParsePosition(position)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
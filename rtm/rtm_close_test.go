@@ -0,0 +1,127 @@
+package rtm
+
+import (
+	"context"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the stacks of running goroutines by goroutine id
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		header := strings.SplitN(stack, " ", 3)
+		if len(header) == 3 && header[0] == "goroutine" {
+			stacks[header[1]] = stack
+		}
+	}
+	return stacks
+}
+
+// Fails if goroutines that were not running before are still running. Goroutines are given some time to exit
+func checkGoroutineLeaks(t *testing.T, before map[string]string) {
+	var leaked []string
+	for i := 0; i < 200; i++ {
+		leaked = nil
+		for id, stack := range goroutines() {
+			if _, ok := before[id]; !ok {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+}
+
+func TestLocal_Close(t *testing.T) {
+	before := goroutines()
+
+	srv := rtmtest.NewServer()
+	srv.HandleFunc("rtm/read", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{
+		PingInterval:     time.Second,
+		AckTimeout:       time.Minute,
+		OfflineQueueSize: 10,
+		ReconnectPolicy:  ConstantReconnect{Delay: time.Minute},
+	})
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	subscribed := make(chan bool, 1)
+	client.Subscribe(getChannel(), subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribed: func(pdu.SubscribeOk) {
+			subscribed <- true
+		},
+	})
+	<-subscribed
+	if response := <-client.PublishAck(getChannel(), "message"); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+
+	// Requests waiting for the response
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	read := client.ReadCtx(ctx, getChannel())
+
+	// Broken connection: the client waits a minute to reconnect
+	awaiting := make(chan bool, 1)
+	client.OnAwaitingOnce(func() {
+		awaiting <- true
+	})
+	srv.CloseConnections()
+	<-awaiting
+	<-read
+	queued := client.PublishAckCtx(ctx, getChannel(), "queued")
+
+	client.Close()
+	if response := <-queued; response.Err == nil {
+		t.Fatal("Queued request succeeded after Close")
+	}
+	srv.Close()
+
+	checkGoroutineLeaks(t, before)
+}
+
+func TestLocal_ClosePool(t *testing.T) {
+	before := goroutines()
+
+	srv := rtmtest.NewServer()
+	pool, _ := NewPool(srv.URL, "local-appkey", 3, Options{})
+	connected := make(chan bool, 1)
+	pool.OnConnectedOnce(func() {
+		connected <- true
+	})
+	pool.Start()
+	<-connected
+
+	pool.Close()
+	srv.Close()
+
+	checkGoroutineLeaks(t, before)
+}
+
+func TestClose_NotStarted(t *testing.T) {
+	before := goroutines()
+
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{})
+	client.Close()
+	client.Close()
+
+	checkGoroutineLeaks(t, before)
+}
@@ -0,0 +1,206 @@
+package rtm
+
+import (
+	"github.com/satori-com/satori-rtm-sdk-go/logger"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_ENDPOINT_RETRY_DELAY = 10 * time.Second
+
+	// The retry delay of a failing endpoint doubles with every failure in a row, up to 2^5 times
+	maxEndpointPenaltyShift = 5
+)
+
+// Returns the endpoints to connect to, in order of preference. The client calls ResolveEndpoints
+// before every connection attempt, so the list can change over time, e.g. when DNS records are updated.
+type EndpointResolver interface {
+	ResolveEndpoints() ([]string, error)
+}
+
+// Adapter to use an ordinary function as EndpointResolver
+type EndpointResolverFunc func() ([]string, error)
+
+func (f EndpointResolverFunc) ResolveEndpoints() ([]string, error) {
+	return f()
+}
+
+type endpointHealth struct {
+	failures int
+	retryAt  time.Time
+}
+
+// Chooses the endpoint for every connection attempt and tracks failing endpoints.
+//
+// The most preferred endpoint that has not failed recently is chosen. A failed endpoint is skipped
+// for the retry delay, which grows with every failure in a row. If all endpoints failed recently,
+// the one that is retried the soonest is chosen, so the client rotates through the endpoints.
+type endpointSet struct {
+	static     []string
+	resolver   EndpointResolver
+	retryDelay time.Duration
+
+	mutex   sync.Mutex
+	health  map[string]*endpointHealth
+	current string
+
+	// Endpoints in the order used to choose the current one. Resolvers can return a different order
+	// every time, e.g. SRVResolver shuffles the records of the same priority, so failback compares
+	// against this order
+	order []string
+}
+
+func newEndpointSet(primary string, opts Options) *endpointSet {
+	static := []string{primary}
+	for _, endpoint := range opts.Endpoints {
+		if len(endpoint) > 0 {
+			static = append(static, appendVersion(endpoint))
+		}
+	}
+
+	retryDelay := opts.EndpointRetryDelay
+	if retryDelay <= 0 {
+		retryDelay = DEFAULT_ENDPOINT_RETRY_DELAY
+	}
+
+	return &endpointSet{
+		static:     static,
+		resolver:   opts.EndpointResolver,
+		retryDelay: retryDelay,
+		health:     make(map[string]*endpointHealth),
+	}
+}
+
+// Returns the endpoints in order of preference. Falls back to the static endpoints if the resolver fails
+func (s *endpointSet) candidates() []string {
+	if s.resolver == nil {
+		return s.static
+	}
+
+	resolved, err := s.resolver.ResolveEndpoints()
+	if err != nil {
+		logger.Warn("Client: Failed to resolve endpoints:", err)
+		return s.static
+	}
+
+	var endpoints []string
+	for _, endpoint := range resolved {
+		if len(endpoint) > 0 {
+			endpoints = append(endpoints, appendVersion(endpoint))
+		}
+	}
+	if len(endpoints) == 0 {
+		logger.Warn("Client: Endpoint resolver returned no endpoints")
+		return s.static
+	}
+	return endpoints
+}
+
+// Chooses the endpoint for the next connection attempt
+func (s *endpointSet) next() string {
+	endpoints := s.candidates()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	chosen := ""
+	var soonest time.Time
+	for _, endpoint := range endpoints {
+		health, ok := s.health[endpoint]
+		if !ok || !health.retryAt.After(now) {
+			chosen = endpoint
+			break
+		}
+		if chosen == "" || health.retryAt.Before(soonest) {
+			chosen = endpoint
+			soonest = health.retryAt
+		}
+	}
+
+	s.current = chosen
+	s.order = endpoints
+	return chosen
+}
+
+// Penalizes the endpoint after the failed connection attempt
+func (s *endpointSet) failed(endpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	health, ok := s.health[endpoint]
+	if !ok {
+		health = &endpointHealth{}
+		s.health[endpoint] = health
+	}
+
+	shift := health.failures
+	if shift > maxEndpointPenaltyShift {
+		shift = maxEndpointPenaltyShift
+	}
+	health.failures++
+	health.retryAt = time.Now().Add(s.retryDelay << uint(shift))
+}
+
+func (s *endpointSet) succeeded(endpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.health, endpoint)
+}
+
+// Reports whether an endpoint that preceded the current one when it was chosen can be tried again
+func (s *endpointSet) preferredAvailable() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, endpoint := range s.order {
+		if endpoint == s.current {
+			return false
+		}
+		if health, ok := s.health[endpoint]; !ok || !health.retryAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *endpointSet) currentEndpoint() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.current
+}
+
+// Returns the endpoint of the current or the last connection attempt.
+// Empty string if the client has not tried to connect yet.
+func (rtm *RTMClient) Endpoint() string {
+	return rtm.endpoints.currentEndpoint()
+}
+
+// Reconnects to a more preferred endpoint once it is available again. Runs while the client is connected
+// to a fallback endpoint; stop is closed when the client leaves STATE_CONNECTED.
+func (rtm *RTMClient) watchFailback(stop <-chan struct{}) {
+	if rtm.opts.FailbackInterval <= 0 {
+		return
+	}
+
+	conn := rtm.conn
+	go func() {
+		ticker := time.NewTicker(rtm.opts.FailbackInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if rtm.endpoints.preferredAvailable() {
+					logger.Info("Client: Preferred endpoint is available, reconnecting")
+					// Closing the transport breaks the read loop: the client reconnects as usual
+					conn.Close()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,187 @@
+package rtm
+
+import (
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEndpointSet_Rotation(t *testing.T) {
+	set := newEndpointSet("wss://primary/v2", Options{
+		Endpoints:          []string{"wss://secondary", "wss://tertiary/"},
+		EndpointRetryDelay: time.Hour,
+	})
+
+	expected := []string{"wss://primary/v2", "wss://secondary/v2", "wss://tertiary/v2"}
+	for _, endpoint := range expected {
+		if next := set.next(); next != endpoint {
+			t.Fatal("Wrong endpoint chosen:", next)
+		}
+		set.failed(endpoint)
+	}
+
+	// All endpoints failed: the one to be retried the soonest is chosen
+	if next := set.next(); next != "wss://primary/v2" {
+		t.Fatal("Endpoints are not rotated:", next)
+	}
+	set.failed("wss://primary/v2")
+	if next := set.next(); next != "wss://secondary/v2" {
+		t.Fatal("Endpoints are not rotated:", next)
+	}
+
+	// Recovered endpoint is preferred again
+	set.succeeded("wss://primary/v2")
+	if next := set.next(); next != "wss://primary/v2" {
+		t.Fatal("Primary endpoint is not preferred:", next)
+	}
+}
+
+func TestEndpointSet_Penalty(t *testing.T) {
+	set := newEndpointSet("wss://primary/v2", Options{
+		Endpoints:          []string{"wss://secondary"},
+		EndpointRetryDelay: 100 * time.Millisecond,
+	})
+
+	set.failed("wss://primary/v2")
+	set.failed("wss://primary/v2")
+	if next := set.next(); next != "wss://secondary/v2" {
+		t.Fatal("Failed endpoint is not skipped:", next)
+	}
+	if set.preferredAvailable() {
+		t.Fatal("Failed endpoint is reported as available")
+	}
+
+	// The second failure in a row doubles the delay
+	time.Sleep(120 * time.Millisecond)
+	if set.preferredAvailable() {
+		t.Fatal("Retry delay is not increased")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !set.preferredAvailable() {
+		t.Fatal("Primary endpoint is not available after the retry delay")
+	}
+	if next := set.next(); next != "wss://primary/v2" {
+		t.Fatal("Primary endpoint is not preferred after the retry delay:", next)
+	}
+}
+
+func TestEndpointSet_FailbackOrder(t *testing.T) {
+	var reversed int32
+	set := newEndpointSet("wss://static/v2", Options{
+		EndpointRetryDelay: 100 * time.Millisecond,
+		EndpointResolver: EndpointResolverFunc(func() ([]string, error) {
+			if atomic.LoadInt32(&reversed) == 1 {
+				return []string{"wss://secondary", "wss://primary"}, nil
+			}
+			return []string{"wss://primary", "wss://secondary"}, nil
+		}),
+	})
+
+	set.failed("wss://primary/v2")
+	if next := set.next(); next != "wss://secondary/v2" {
+		t.Fatal("Failed endpoint is not skipped:", next)
+	}
+
+	// The order changes after connecting: failback still compares against the order used to connect
+	atomic.StoreInt32(&reversed, 1)
+	time.Sleep(120 * time.Millisecond)
+	if !set.preferredAvailable() {
+		t.Fatal("Recovered primary endpoint is not available")
+	}
+	set.succeeded("wss://secondary/v2")
+	if next := set.next(); next != "wss://secondary/v2" {
+		t.Fatal("Wrong endpoint chosen:", next)
+	}
+	if set.preferredAvailable() {
+		t.Fatal("Endpoint that follows the current one is reported as preferred")
+	}
+}
+
+func TestEndpointSet_Resolver(t *testing.T) {
+	var fail int32
+	set := newEndpointSet("wss://static/v2", Options{
+		EndpointResolver: EndpointResolverFunc(func() ([]string, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, errors.New("Resolver failed")
+			}
+			return []string{"wss://resolved"}, nil
+		}),
+	})
+
+	if next := set.next(); next != "wss://resolved/v2" {
+		t.Fatal("Resolved endpoint is not used:", next)
+	}
+	atomic.StoreInt32(&fail, 1)
+	if next := set.next(); next != "wss://static/v2" {
+		t.Fatal("Static endpoint is not used when the resolver fails:", next)
+	}
+}
+
+func TestLocal_Failover(t *testing.T) {
+	down := rtmtest.NewServer()
+	down.Close()
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(down, Options{
+		Endpoints:       []string{srv.URL},
+		ReconnectPolicy: ConstantReconnect{Delay: 10 * time.Millisecond},
+	})
+	defer client.Stop()
+
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	if client.Endpoint() != appendVersion(srv.URL) {
+		t.Fatal("Client is not connected to the fallback endpoint:", client.Endpoint())
+	}
+}
+
+func TestLocal_Failback(t *testing.T) {
+	primary := rtmtest.NewServer()
+	defer primary.Close()
+	fallback := rtmtest.NewServer()
+	defer fallback.Close()
+
+	var primaryDown int32 = 1
+	client := getLocalRTM(primary, Options{
+		Endpoints:          []string{fallback.URL},
+		ReconnectPolicy:    ConstantReconnect{Delay: 10 * time.Millisecond},
+		EndpointRetryDelay: 50 * time.Millisecond,
+		FailbackInterval:   20 * time.Millisecond,
+		Transport: func(endpoint string) (connection.Transport, error) {
+			if strings.HasPrefix(endpoint, primary.URL) && atomic.LoadInt32(&primaryDown) == 1 {
+				return nil, errors.New("Primary endpoint is down")
+			}
+			conn, err := connection.New(endpoint, connection.Options{})
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		},
+	})
+	defer client.Stop()
+
+	connected := make(chan string, 10)
+	client.OnConnected(func() {
+		connected <- client.Endpoint()
+	})
+	go client.Start()
+
+	for _, expected := range []string{fallback.URL, primary.URL} {
+		select {
+		case endpoint := <-connected:
+			if endpoint != appendVersion(expected) {
+				t.Fatal("Client is connected to the wrong endpoint:", endpoint)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Client is not connected")
+		}
+		atomic.StoreInt32(&primaryDown, 0)
+	}
+}
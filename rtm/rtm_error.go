@@ -6,6 +6,7 @@ const (
 	ERROR_CODE_PDU            = 2
 	ERROR_CODE_INVALID_JSON   = 3
 	ERROR_CODE_AUTHENTICATION = 4
+	ERROR_CODE_CONTEXT        = 5
 )
 
 type RTMError struct {
@@ -19,3 +20,9 @@ func (re RTMError) Error() string {
 	}
 	return "Unknow error"
 }
+
+// Returns the Reason, so errors.Is and errors.As can check the underlying error,
+// e.g. connection.ERROR_CONNECTION_LOST, context.Canceled or pdu.ServerError
+func (re RTMError) Unwrap() error {
+	return re.Reason
+}
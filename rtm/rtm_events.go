@@ -15,6 +15,8 @@ const (
 	EVENT_CLOSE            = "close"
 	EVENT_ERROR            = "error"
 	EVENT_AUTHENTICATED    = "authenticated"
+	EVENT_GIVE_UP          = "giveUp"
+	EVENT_RECONNECT        = "reconnect"
 )
 
 // EVENT_STOPPED
@@ -167,3 +169,14 @@ func (rtm *RTMClient) OnAuthenticatedOnce(callback func()) {
 		callback()
 	})
 }
+
+func (rtm *RTMClient) OnGiveUp(callback func()) interface{} {
+	return rtm.On(EVENT_GIVE_UP, func(data interface{}) {
+		callback()
+	})
+}
+func (rtm *RTMClient) OnGiveUpOnce(callback func()) {
+	rtm.Once(EVENT_GIVE_UP, func(data interface{}) {
+		callback()
+	})
+}
@@ -15,7 +15,7 @@ import (
 	"time"
 )
 
-func ExampleRTM_Publish() {
+func ExampleRTMClient_Publish() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -41,7 +41,7 @@ func ExampleRTM_Publish() {
 	logger.Info("Message has been sent")
 }
 
-func ExampleRTM_Publish_types() {
+func ExampleRTMClient_Publish_types() {
 	authProvider := auth.New("<your-role>", "<your-rolekey>")
 	client, _ := rtm.New("<your-endpoint>", "<your-appkey>", rtm.Options{
 		AuthProvider: authProvider,
@@ -78,7 +78,7 @@ func ExampleRTM_Publish_types() {
 	client.Publish("<your-channel>", nil)
 }
 
-func ExampleRTM_PublishAck_simple() {
+func ExampleRTMClient_PublishAck_simple() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -106,7 +106,7 @@ func ExampleRTM_PublishAck_simple() {
 	logger.Info(response)
 }
 
-func ExampleRTM_PublishAck_processErrors() {
+func ExampleRTMClient_PublishAck_processErrors() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -145,7 +145,7 @@ func ExampleRTM_PublishAck_processErrors() {
 	}
 }
 
-func ExampleRTM_Write_simple() {
+func ExampleRTMClient_Write_simple() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -172,7 +172,7 @@ func ExampleRTM_Write_simple() {
 	})
 }
 
-func ExampleRTM_Write_processErrors() {
+func ExampleRTMClient_Write_processErrors() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -212,7 +212,7 @@ func ExampleRTM_Write_processErrors() {
 	}
 }
 
-func ExampleRTM_Read_simple() {
+func ExampleRTMClient_Read_simple() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -243,7 +243,7 @@ func ExampleRTM_Read_simple() {
 	fmt.Printf("Postition: %s; Data: %s\n", string(r.Response.Position), string(r.Response.Message))
 }
 
-func ExampleRTM_Read_processErrors() {
+func ExampleRTMClient_Read_processErrors() {
 	type Animal struct {
 		Who   string    `json:"who"`
 		Where []float32 `json:"where"`
@@ -287,7 +287,7 @@ func ExampleRTM_Read_processErrors() {
 	}
 }
 
-func ExampleRTM_Subscribe() {
+func ExampleRTMClient_Subscribe() {
 	type Point struct {
 		Id int
 	}
@@ -339,7 +339,7 @@ func ExampleRTM_Subscribe() {
 	<-time.After(10 * time.Second)
 }
 
-func ExampleRTM_Subscribe_processErrors() {
+func ExampleRTMClient_Subscribe_processErrors() {
 	type Point struct {
 		Id int
 	}
@@ -410,7 +410,7 @@ func ExampleRTM_Subscribe_processErrors() {
 	<-time.After(10 * time.Second)
 }
 
-func ExampleRTM() {
+func ExampleRTMClient() {
 	authProvider := auth.New("<your-role>", "<your-rolekey>")
 	client, err := rtm.New("<your-endpoint>", "<your-appkey>", rtm.Options{
 		AuthProvider: authProvider,
@@ -439,7 +439,7 @@ func ExampleRTM() {
 	}
 }
 
-func ExampleRTM_Proxy_fromEnv() {
+func ExampleNew_proxyFromEnv() {
 	client, err := rtm.New("<your-endpoint>", "<your-appkey>", rtm.Options{
 		Proxy: http.ProxyFromEnvironment,
 	})
@@ -450,7 +450,7 @@ func ExampleRTM_Proxy_fromEnv() {
 	client.Start()
 }
 
-func ExampleRTM_Proxy_fromUrl() {
+func ExampleNew_proxyFromUrl() {
 	proxyUrl, _ := url.Parse("http://127.0.0.1:3128")
 	client, err := rtm.New("<your-endpoint>", "<your-appkey>", rtm.Options{
 		Proxy: http.ProxyURL(proxyUrl),
@@ -3,7 +3,7 @@ package rtm
 import (
 	"github.com/satori-com/satori-rtm-sdk-go/fsm"
 	"github.com/satori-com/satori-rtm-sdk-go/logger"
-	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,12 +13,14 @@ func (rtm *RTMClient) initFSM() {
 			EVENT_STOPPED: func(f *fsm.FSM) {
 				logger.Info("Client: Enter Stopped")
 				rtm.closeConnection()
+				rtm.failOfflineQueue(ERROR_NOT_CONNECTED)
 				rtm.Fire(EVENT_STOPPED, nil)
 			},
 			EVENT_LEAVE_STOPPED: func(f *fsm.FSM) {
 				rtm.Fire(EVENT_LEAVE_STOPPED, nil)
 			},
 			EVENT_START: func(f *fsm.FSM) {
+				atomic.StoreInt32(&rtm.shuttingDown, 0)
 				f.Transition(STATE_CONNECTING)
 			},
 		},
@@ -53,10 +55,14 @@ func (rtm *RTMClient) initFSM() {
 				logger.Info("Client: Enter Connected")
 				rtm.Fire(EVENT_CONNECTED, nil)
 				rtm.reconnectCount = 0
+				rtm.lastReconnectDelay = 0
 				rtm.subscribeAll()
-
+				rtm.flushOfflineQueue()
+				rtm.failbackStop = make(chan struct{})
+				rtm.watchFailback(rtm.failbackStop)
 			},
 			EVENT_LEAVE_CONNECTED: func(f *fsm.FSM) {
+				close(rtm.failbackStop)
 				rtm.Fire(EVENT_LEAVE_CONNECTED, nil)
 				rtm.disconnectAll()
 			},
@@ -73,17 +79,37 @@ func (rtm *RTMClient) initFSM() {
 				rtm.Fire(EVENT_AWAITING, nil)
 				rtm.closeConnection()
 
-				go func() {
-					reconnectTime := rtm.nextReconnectInterval()
+				reconnectTime, ok := rtm.nextReconnectInterval()
+				if !ok {
+					logger.Warn("Client: Give up reconnecting after", rtm.reconnectCount, "attempts")
+					f.Transition(STATE_STOPPED)
+					rtm.Fire(EVENT_GIVE_UP, nil)
+					return
+				}
+				rtm.reconnectCount++
+				rtm.lastReconnectDelay = reconnectTime
+
+				// Transitions are made from the event queue only: the FSM is not thread-safe
+				rtm.reconnectStop = make(chan struct{})
+				go func(stop <-chan struct{}) {
 					logger.Info("Client: Reconnect after", reconnectTime)
-					<-time.After(reconnectTime)
-					rtm.reconnectCount++
-					if f.CurrentState() == STATE_AWAITING {
-						f.Transition(STATE_CONNECTING)
+					timer := time.NewTimer(reconnectTime)
+					defer timer.Stop()
+					select {
+					case <-timer.C:
+						rtm.Fire(EVENT_RECONNECT, nil)
+					case <-stop:
 					}
-				}()
+				}(rtm.reconnectStop)
+			},
+			EVENT_RECONNECT: func(f *fsm.FSM) {
+				f.Transition(STATE_CONNECTING)
 			},
 			EVENT_LEAVE_AWAITING: func(f *fsm.FSM) {
+				if rtm.reconnectStop != nil {
+					close(rtm.reconnectStop)
+					rtm.reconnectStop = nil
+				}
 				rtm.Fire(EVENT_LEAVE_AWAITING, nil)
 			},
 			EVENT_STOP: func(f *fsm.FSM) {
@@ -92,7 +118,7 @@ func (rtm *RTMClient) initFSM() {
 		},
 	})
 
-	events := []string{EVENT_OPEN, EVENT_START, EVENT_STOP, EVENT_CLOSE}
+	events := []string{EVENT_OPEN, EVENT_START, EVENT_STOP, EVENT_CLOSE, EVENT_RECONNECT}
 	for _, event := range events {
 		func(event string) {
 			rtm.On(event, func(data interface{}) {
@@ -101,12 +127,3 @@ func (rtm *RTMClient) initFSM() {
 		}(event)
 	}
 }
-
-func (rtm *RTMClient) nextReconnectInterval() time.Duration {
-	reconnect_sec := rtm.reconnectCount * rtm.reconnectCount
-	if reconnect_sec > MAX_RECONNECT_TIME_SEC {
-		reconnect_sec = MAX_RECONNECT_TIME_SEC
-	}
-	jitter := rand.Intn(100)
-	return time.Duration(reconnect_sec)*time.Second + time.Duration(jitter)*time.Millisecond
-}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/satori-com/satori-rtm-sdk-go/rtm/auth"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -34,11 +35,21 @@ func getRTM() (*RTMClient, error) {
 	return client, nil
 }
 
+// Creates a client for the local RTM test server
+func getLocalRTM(srv *rtmtest.Server, opts Options) *RTMClient {
+	client, _ := New(srv.URL, "local-appkey", opts)
+	return client
+}
+
 func waitForConnected(rtm *RTMClient) error {
-	connected := make(chan bool)
-	rtm.On(EVENT_CONNECTED, func(interface{}) {
-		connected <- true
+	connected := make(chan bool, 1)
+	id := rtm.On(EVENT_CONNECTED, func(interface{}) {
+		select {
+		case connected <- true:
+		default:
+		}
 	})
+	defer rtm.Off(EVENT_CONNECTED, id)
 	select {
 	case <-connected:
 		return nil
@@ -0,0 +1,1130 @@
+package rtm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/auth"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal_PublishSubscribe(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+
+	channel := getChannel()
+	subscribed := make(chan bool)
+	messages := make(chan string, 1)
+	client.Subscribe(channel, subscription.RELIABLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribed: func(sok pdu.SubscribeOk) {
+			subscribed <- true
+		},
+		OnData: func(data pdu.SubscriptionData) {
+			for _, message := range data.Messages {
+				messages <- string(message)
+			}
+		},
+	})
+
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unable to subscribe")
+	}
+
+	response := <-client.PublishAck(channel, "hello")
+	if response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	if len(response.Response.Position) == 0 {
+		t.Fatal("Publish response has no position")
+	}
+
+	select {
+	case message := <-messages:
+		if message != "\"hello\"" {
+			t.Fatal("Wrong message: " + message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unable to get the published message")
+	}
+
+	unsubscribe := <-client.Unsubscribe(channel)
+	if unsubscribe.Err != nil {
+		t.Fatal(unsubscribe.Err)
+	}
+	if _, err := client.GetSubscription(channel); err != ERROR_SUBSCRIPTION_NOT_FOUND {
+		t.Fatal("Subscription still exists after unsubscribing")
+	}
+}
+
+func TestLocal_WriteReadDelete(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	type A struct {
+		Name string
+		Age  int
+	}
+	channel := getChannel()
+
+	write := <-client.Write(channel, A{"Bob", 42})
+	if write.Err != nil {
+		t.Fatal(write.Err)
+	}
+
+	read := <-client.Read(channel)
+	if read.Err != nil {
+		t.Fatal(read.Err)
+	}
+	var value A
+	json.Unmarshal(read.Response.Message, &value)
+	if value.Name != "Bob" || value.Age != 42 {
+		t.Fatal("Read wrong value: " + string(read.Response.Message))
+	}
+
+	readPos := <-client.ReadPos(channel, write.Response.Position)
+	if readPos.Err != nil || string(readPos.Response.Message) != string(read.Response.Message) {
+		t.Fatal("Unable to read value by position")
+	}
+
+	del := <-client.Delete(channel)
+	if del.Err != nil {
+		t.Fatal(del.Err)
+	}
+
+	read = <-client.Read(channel)
+	if string(read.Response.Message) != "null" {
+		t.Fatal("Value was not deleted")
+	}
+}
+
+func TestLocal_Auth(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.AddRole("role", "secret")
+
+	client := getLocalRTM(srv, Options{
+		AuthProvider: auth.New("role", "secret"),
+	})
+	defer client.Stop()
+
+	authenticated := make(chan bool, 1)
+	client.OnAuthenticatedOnce(func() {
+		authenticated <- true
+	})
+	go client.Start()
+
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-authenticated:
+	default:
+		t.Fatal("Connected without authentication")
+	}
+}
+
+func TestLocal_WrongAuth(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.AddRole("role", "secret")
+
+	client := getLocalRTM(srv, Options{
+		AuthProvider: auth.New("role", "wrong-secret"),
+	})
+	defer client.Stop()
+
+	event := make(chan RTMError, 1)
+	client.OnErrorOnce(func(err RTMError) {
+		event <- err
+	})
+	go client.Start()
+
+	select {
+	case err := <-event:
+		if err.Code != ERROR_CODE_AUTHENTICATION {
+			t.Fatal("Wrong error type returned:", err)
+		}
+		if !errors.Is(err, pdu.ERROR_AUTHENTICATION_FAILED) {
+			t.Fatal("Authentication error does not wrap the server error:", err.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cannot get authentication error")
+	}
+}
+
+func TestLocal_ServerError(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	channel := getChannel()
+	srv.RestrictChannel(channel)
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	response := <-client.PublishAck(channel, 1)
+	if !errors.Is(response.Err, pdu.ERROR_AUTHORIZATION_DENIED) {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+	var serverErr pdu.ServerError
+	if !errors.As(response.Err, &serverErr) || serverErr.Action != "rtm/publish/error" || serverErr.Code != "authorization_denied" {
+		t.Fatal("Error does not contain the server error:", response.Err)
+	}
+	if errors.Is(response.Err, connection.ERROR_CONNECTION_LOST) {
+		t.Fatal("Server error matches the transport error")
+	}
+}
+
+func TestLocal_Reconnect(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+
+	channel := getChannel()
+	subscribed := make(chan bool, 2)
+	client.Subscribe(channel, subscription.RELIABLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribed: func(sok pdu.SubscribeOk) {
+			subscribed <- true
+		},
+	})
+
+	connected := make(chan bool, 2)
+	client.OnConnected(func() {
+		connected <- true
+	})
+	go client.Start()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Unable to connect")
+		}
+		select {
+		case <-subscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Unable to subscribe")
+		}
+
+		// Drop the connection. The client should reconnect and resubscribe
+		srv.CloseConnections()
+	}
+}
+
+func TestLocal_ResubscribeMaxInFlight(t *testing.T) {
+	for _, block := range []bool{false, true} {
+		srv := rtmtest.NewServer()
+
+		client := getLocalRTM(srv, Options{
+			MaxInFlight:        2,
+			BlockOnMaxInFlight: block,
+		})
+
+		channels := make([]string, 5)
+		subscribed := make(chan string, len(channels))
+		messages := make(chan string, len(channels))
+		for i := range channels {
+			channels[i] = getChannel()
+			client.Subscribe(channels[i], subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+				OnSubscribed: func(sok pdu.SubscribeOk) {
+					subscribed <- sok.SubscriptionId
+				},
+				OnData: func(data pdu.SubscriptionData) {
+					messages <- data.SubscriptionId
+				},
+			})
+		}
+		go client.Start()
+
+		// Subscriptions are sent when the client connects and again after reconnecting
+		for attempt := 0; attempt < 2; attempt++ {
+			for range channels {
+				select {
+				case <-subscribed:
+				case <-time.After(5 * time.Second):
+					t.Fatal("Not all subscriptions are restored, BlockOnMaxInFlight:", block)
+				}
+			}
+			if attempt == 0 {
+				srv.CloseConnections()
+			}
+		}
+
+		for _, channel := range channels {
+			srv.Publish(channel, json.RawMessage(`"message"`))
+		}
+		for range channels {
+			select {
+			case <-messages:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Message is not received after resubscribing, BlockOnMaxInFlight:", block)
+			}
+		}
+
+		client.Stop()
+		srv.Close()
+	}
+}
+
+func TestLocal_PublishAckCtx_Timeout(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	select {
+	case response := <-client.PublishAckCtx(ctx, getChannel(), "hello"):
+		rtmErr, ok := response.Err.(RTMError)
+		if !ok || rtmErr.Code != ERROR_CODE_CONTEXT || rtmErr.Reason != context.DeadlineExceeded {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PublishAckCtx did not return after the deadline")
+	}
+}
+
+func TestLocal_Ctx_Canceled(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	response := <-client.WriteCtx(ctx, getChannel(), 1)
+	rtmErr, ok := response.Err.(RTMError)
+	if !ok || rtmErr.Code != ERROR_CODE_CONTEXT || rtmErr.Reason != context.Canceled {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+
+	if err := client.PublishCtx(ctx, getChannel(), 1); err == nil {
+		t.Fatal("Published with canceled context")
+	}
+
+	// Background context works as usual
+	read := <-client.ReadCtx(context.Background(), getChannel())
+	if read.Err != nil {
+		t.Fatal(read.Err)
+	}
+}
+
+func TestLocal_SubscribeCtx_Timeout(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/subscribe", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	subscribeError := make(chan pdu.SubscribeError, 1)
+	channel := getChannel()
+	err := client.SubscribeCtx(ctx, channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribeError: func(err pdu.SubscribeError) {
+			subscribeError <- err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-subscribeError:
+		if err.Error != context.DeadlineExceeded.Error() || err.SubscriptionId != channel {
+			t.Fatal("Wrong subscribe error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SubscribeCtx did not fail after the deadline")
+	}
+}
+
+func TestLocal_AckTimeout(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/write", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{
+		AckTimeout: 100 * time.Millisecond,
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case response := <-client.Write(getChannel(), 1):
+		rtmErr, ok := response.Err.(RTMError)
+		if !ok || rtmErr.Code != ERROR_CODE_TRANSPORT || rtmErr.Reason != connection.ERROR_ACK_TIMEOUT {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write did not time out")
+	}
+}
+
+func TestLocal_ConnectionLost(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	received := make(chan bool, 1)
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		received <- true
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	responseCh := client.PublishAck(getChannel(), 1)
+	<-received
+	srv.CloseConnections()
+
+	select {
+	case response := <-responseCh:
+		rtmErr, ok := response.Err.(RTMError)
+		if !ok || rtmErr.Code != ERROR_CODE_TRANSPORT || rtmErr.Reason != connection.ERROR_CONNECTION_LOST {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PublishAck did not fail after connection is lost")
+	}
+}
+
+func TestLocal_KeepAlive(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		PingInterval: 20 * time.Millisecond,
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	awaiting := make(chan bool, 1)
+	client.OnAwaitingOnce(func() {
+		awaiting <- true
+	})
+	srv.IgnorePings(true)
+
+	select {
+	case <-awaiting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not detect the dead connection")
+	}
+}
+
+func TestLocal_TLS(t *testing.T) {
+	srv := rtmtest.NewTLSServer()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	client := getLocalRTM(srv, Options{
+		TLSClientConfig: &tls.Config{
+			RootCAs: pool,
+		},
+		PinnedPublicKeys: []string{connection.PublicKeyPin(srv.Certificate())},
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocal_DialOptions(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	userAgent := make(chan string, 1)
+	srv.HandleFunc("rtm/read", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		userAgent <- conn.Header().Get("User-Agent")
+		conn.Reply(query, "ok", pdu.ReadBodyResponse{})
+	})
+
+	client := getLocalRTM(srv, Options{
+		Header:           http.Header{"User-Agent": []string{"rtm-test/1.0"}},
+		DialTimeout:      time.Second,
+		HandshakeTimeout: time.Second,
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	<-client.Read(getChannel())
+	if ua := <-userAgent; ua != "rtm-test/1.0" {
+		t.Fatal("Wrong User-Agent header:", ua)
+	}
+}
+
+func TestLocal_Compression(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		EnableCompression:    true,
+		CompressionThreshold: 64,
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := getChannel()
+	value := strings.Repeat("compressible ", 1000)
+	for _, message := range []string{"short", value} {
+		if write := <-client.Write(channel, message); write.Err != nil {
+			t.Fatal(write.Err)
+		}
+		read := <-client.Read(channel)
+		var got string
+		json.Unmarshal(read.Response.Message, &got)
+		if read.Err != nil || got != message {
+			t.Fatal("Read wrong value:", read.Err)
+		}
+	}
+}
+
+func TestLocal_CBOR(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		Codec: pdu.CBORCodec{},
+	})
+	defer client.Stop()
+
+	type Frame struct {
+		Id      int    `json:"frame_id"`
+		Payload []byte `json:"payload"`
+	}
+	channel := getChannel()
+	frames := make(chan Frame, 1)
+	subscribed := make(chan bool, 1)
+	client.Subscribe(channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribed: func(sok pdu.SubscribeOk) {
+			subscribed <- true
+		},
+		OnData: func(data pdu.SubscriptionData) {
+			for _, message := range data.Messages {
+				var frame Frame
+				json.Unmarshal(message, &frame)
+				frames <- frame
+			}
+		},
+	})
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	<-subscribed
+
+	if response := <-client.PublishAck(channel, Frame{1, []byte{0, 1, 254, 255}}); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	select {
+	case frame := <-frames:
+		if frame.Id != 1 || string(frame.Payload) != string([]byte{0, 1, 254, 255}) {
+			t.Fatal("Wrong frame received:", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unable to get the published frame")
+	}
+
+	write := <-client.Write(channel, func() {})
+	if rtmErr, ok := write.Err.(RTMError); !ok || rtmErr.Code != ERROR_CODE_INVALID_JSON {
+		t.Fatal("Wrong error returned:", write.Err)
+	}
+	if !client.IsConnected() {
+		t.Fatal("Encoding error closed the connection")
+	}
+}
+
+func TestLocal_MaxInFlight(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{
+		MaxInFlight:         1,
+		EnableWriteBatching: true,
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	client.PublishAck(getChannel(), 1)
+	response := <-client.PublishAck(getChannel(), 2)
+	rtmErr, ok := response.Err.(RTMError)
+	if !ok || rtmErr.Code != ERROR_CODE_APPLICATION || rtmErr.Reason != connection.ERROR_TOO_MANY_IN_FLIGHT {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+	if !client.IsConnected() {
+		t.Fatal("Full in-flight window closed the connection")
+	}
+
+}
+
+func TestLocal_MaxInFlightBlock(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{
+		MaxInFlight:        1,
+		BlockOnMaxInFlight: true,
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client.PublishAck(getChannel(), 1)
+	response := <-client.PublishAckCtx(ctx, getChannel(), 2)
+	if rtmErr, ok := response.Err.(RTMError); !ok || rtmErr.Code != ERROR_CODE_CONTEXT {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+	if !client.IsConnected() {
+		t.Fatal("Context done while waiting for a free slot closed the connection")
+	}
+}
+
+func TestLocal_PublishTTL(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	expiring, replaced := getChannel(), getChannel()
+	opts := pdu.PublishBodyOpts{
+		Ttl:        1,
+		TtlMessage: "offline",
+	}
+	if response := <-client.PublishAckWithOptions(expiring, "online", opts); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	if err := client.PublishWithOptions(replaced, "online", opts); err != nil {
+		t.Fatal(err)
+	}
+	// Another message cancels the TTL message
+	if response := <-client.PublishAck(replaced, "still online"); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+
+	if read := <-client.Read(expiring); read.Err != nil || string(read.Response.Message) != `"online"` {
+		t.Fatal("Message is replaced before its TTL expired:", read)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if read := <-client.Read(expiring); read.Err != nil || string(read.Response.Message) != `"offline"` {
+		t.Fatal("TTL message is not published:", read)
+	}
+	if read := <-client.Read(replaced); read.Err != nil || string(read.Response.Message) != `"still online"` {
+		t.Fatal("TTL message is published after another message:", read)
+	}
+
+	response := <-client.PublishAckWithOptions(expiring, "online", pdu.PublishBodyOpts{TtlMessage: "offline"})
+	if !errors.Is(response.Err, pdu.ERROR_INVALID_FORMAT) {
+		t.Fatal("TTL message without TTL is accepted:", response.Err)
+	}
+}
+
+func TestLocal_SubscribeOnlyValue(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := getChannel()
+	for i := 1; i <= 3; i++ {
+		if response := <-client.Write(channel, i); response.Err != nil {
+			t.Fatal(response.Err)
+		}
+	}
+
+	messages := make(chan string, 10)
+	err := client.Subscribe(channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{
+		Only:    pdu.ONLY_VALUE,
+		History: pdu.SubscribeHistory{Count: 10},
+	}, subscription.Listener{
+		OnData: func(data pdu.SubscriptionData) {
+			for _, message := range data.Messages {
+				messages <- string(message)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"3", "4"} {
+		select {
+		case message := <-messages:
+			if message != expected {
+				t.Fatal("Wrong value received:", message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Value is not received")
+		}
+		client.Write(channel, 4)
+	}
+}
+
+func TestLocal_DeletePurge(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := getChannel()
+	written := <-client.Write(channel, "value")
+	if written.Err != nil {
+		t.Fatal(written.Err)
+	}
+	if response := <-client.Delete(channel); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	if read := <-client.ReadPos(channel, written.Response.Position); string(read.Response.Message) != `"value"` {
+		t.Fatal("Delete without purge removed the history:", read)
+	}
+
+	if response := <-client.DeleteWithOptions(channel, pdu.DeleteBodyOpts{Purge: true}); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	if read := <-client.ReadPos(channel, written.Response.Position); read.Err != nil || string(read.Response.Message) != "null" {
+		t.Fatal("Delete with purge kept the history:", read)
+	}
+}
+
+func TestLocal_Request(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := getChannel()
+	if response := <-client.Write(channel, "value"); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	response, err := client.Request(context.Background(), "rtm/read", pdu.ReadBody{Channel: channel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read pdu.ReadBodyResponse
+	json.Unmarshal(response.Body, &read)
+	if response.Action != "rtm/read/ok" || string(read.Message) != `"value"` {
+		t.Fatal("Wrong response:", response)
+	}
+
+	response, err = client.Request(context.Background(), "rtm/unknown", json.RawMessage(`{}`))
+	if response.Action != "rtm/unknown/error" || !errors.Is(err, pdu.ERROR_INVALID_SERVICE) {
+		t.Fatal("Wrong error response:", response, err)
+	}
+}
+
+func TestLocal_RequestStream(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/search", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		for _, channel := range []string{"a", "b", "c"} {
+			conn.Send(pdu.RTMQuery{
+				Action: "rtm/search/data",
+				Id:     query.Id,
+				Body:   json.RawMessage(`{"channels":["` + channel + `"]}`),
+			})
+		}
+		conn.Reply(query, "ok", map[string][]string{"channels": {}})
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for response := range client.RequestStream(context.Background(), "rtm/search", map[string]string{"prefix": ""}) {
+		if response.Err != nil {
+			t.Fatal(response.Err)
+		}
+		actions = append(actions, response.Response.Action)
+	}
+	if strings.Join(actions, ",") != "rtm/search/data,rtm/search/data,rtm/search/data,rtm/search/ok" {
+		t.Fatal("Wrong responses:", actions)
+	}
+
+	var responses []RequestResponse
+	for response := range client.RequestStream(context.Background(), "rtm/unknown", map[string]string{}) {
+		responses = append(responses, response)
+	}
+	if len(responses) != 1 || !errors.Is(responses[0].Err, pdu.ERROR_INVALID_SERVICE) {
+		t.Fatal("Wrong error response:", responses)
+	}
+}
+
+func TestLocal_Search(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	for i := 0; i < rtmtest.SEARCH_BATCH_SIZE+50; i++ {
+		srv.Publish(fmt.Sprintf("devices.eu.%03d", i), json.RawMessage(`{}`))
+	}
+	srv.Publish("devices.us.000", json.RawMessage(`{}`))
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	var batches []int
+	for response := range client.SearchStream(context.Background(), "devices.eu.") {
+		if response.Err != nil {
+			t.Fatal(response.Err)
+		}
+		batches = append(batches, len(response.Response.Channels))
+	}
+	if len(batches) != 2 || batches[0] != rtmtest.SEARCH_BATCH_SIZE || batches[1] != 50 {
+		t.Fatal("Wrong search batches:", batches)
+	}
+
+	response := <-client.Search(context.Background(), "devices.eu.")
+	if response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	channels := response.Response.Channels
+	if len(channels) != rtmtest.SEARCH_BATCH_SIZE+50 || channels[0] != "devices.eu.000" || channels[len(channels)-1] != "devices.eu.149" {
+		t.Fatal("Wrong search result:", len(channels))
+	}
+
+	response = <-client.Search(context.Background(), "unknown.")
+	if response.Err != nil || len(response.Response.Channels) != 0 {
+		t.Fatal("Wrong empty search result:", response)
+	}
+}
+
+func TestLocal_SearchError(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/search", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Send(pdu.RTMQuery{
+			Action: "rtm/search/data",
+			Id:     query.Id,
+			Body:   json.RawMessage(`{"channels":["a"]}`),
+		})
+		conn.ReplyError(query, "authorization_denied", "Unauthorized")
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	response := <-client.Search(context.Background(), "")
+	if !errors.Is(response.Err, pdu.ERROR_AUTHORIZATION_DENIED) || response.Response.Channels != nil {
+		t.Fatal("Wrong search error:", response)
+	}
+}
+
+func TestLocal_SearchInvalidResponse(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/search", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		conn.Send(pdu.RTMQuery{
+			Action: "rtm/search/data",
+			Id:     query.Id,
+			Body:   json.RawMessage(`{"channels":"a"}`),
+		})
+		conn.Reply(query, "ok", pdu.SearchBodyResponse{Channels: []string{"b"}})
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	var responses []SearchResponse
+	for response := range client.SearchStream(context.Background(), "") {
+		responses = append(responses, response)
+	}
+	if len(responses) != 2 {
+		t.Fatal("Wrong number of responses:", responses)
+	}
+	if rtmErr, ok := responses[0].Err.(RTMError); !ok || rtmErr.Code != ERROR_CODE_INVALID_JSON {
+		t.Fatal("Invalid response did not fail with ERROR_CODE_INVALID_JSON:", responses[0].Err)
+	}
+	if responses[1].Err != nil || len(responses[1].Response.Channels) != 1 {
+		t.Fatal("Wrong response after the invalid one:", responses[1])
+	}
+}
+
+func TestLocal_PublishSubscribeTyped(t *testing.T) {
+	type animal struct {
+		Who   string     `json:"who"`
+		Where [2]float32 `json:"where"`
+	}
+
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+
+	channel := getChannel()
+	subscribed := make(chan bool, 1)
+	animals := make(chan []animal, 1)
+	decodeErrors := make(chan string, 1)
+	SubscribeTyped(client, channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, TypedListener[animal]{
+		OnData: func(data []animal) {
+			animals <- data
+		},
+		OnDecodeError: func(message json.RawMessage, err error) {
+			decodeErrors <- string(message)
+		},
+		Listener: subscription.Listener{
+			OnSubscribed: func(pdu.SubscribeOk) {
+				subscribed <- true
+			},
+		},
+	})
+	go client.Start()
+	<-subscribed
+
+	if response := <-PublishTyped(client, channel, animal{Who: "zebra", Where: [2]float32{34.1, -118.2}}); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	select {
+	case data := <-animals:
+		if len(data) != 1 || data[0].Who != "zebra" || data[0].Where[1] != -118.2 {
+			t.Fatal("Wrong decoded messages:", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unable to get the typed message")
+	}
+
+	srv.Publish(channel, json.RawMessage(`"not an animal"`))
+	select {
+	case message := <-decodeErrors:
+		if message != `"not an animal"` {
+			t.Fatal("Wrong message passed to OnDecodeError:", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnDecodeError is not called")
+	}
+	select {
+	case data := <-animals:
+		t.Fatal("OnData is called without decoded messages:", data)
+	default:
+	}
+}
+
+func TestLocal_ResubscribeErrorKeepsSubscription(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := getChannel()
+	subscribed := make(chan bool, 1)
+	messages := make(chan string, 1)
+	client.Subscribe(channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribed: func(pdu.SubscribeOk) {
+			subscribed <- true
+		},
+		OnData: func(data pdu.SubscriptionData) {
+			messages <- string(data.Messages[0])
+		},
+	})
+	<-subscribed
+	first, err := client.GetSubscription(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscribeErr := make(chan string, 1)
+	client.Subscribe(channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{
+		Position: "wrong_position",
+	}, subscription.Listener{
+		OnSubscribeError: func(err pdu.SubscribeError) {
+			subscribeErr <- err.Error
+		},
+	})
+	if code := <-subscribeErr; code != "invalid_format" {
+		t.Fatal("Wrong subscribe error:", code)
+	}
+
+	sub, err := client.GetSubscription(channel)
+	if err != nil || sub != first {
+		t.Fatal("Rejected subscription replaced the previous one:", err)
+	}
+	srv.Publish(channel, json.RawMessage(`"after error"`))
+	select {
+	case message := <-messages:
+		if message != `"after error"` {
+			t.Fatal("Wrong message:", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The previous subscription does not receive messages")
+	}
+}
+
+func TestLocal_SubscriptionInfoError(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+
+	channel := getChannel()
+	subscribed := make(chan bool, 1)
+	info := make(chan pdu.SubscriptionInfo, 1)
+	subscriptionErr := make(chan pdu.SubscriptionError, 1)
+	unsubscribed := make(chan bool, 1)
+	client.Subscribe(channel, subscription.RELIABLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnSubscribed: func(pdu.SubscribeOk) {
+			subscribed <- true
+		},
+		OnSubscriptionInfo: func(data pdu.SubscriptionInfo) {
+			info <- data
+		},
+		OnSubscriptionError: func(data pdu.SubscriptionError) {
+			subscriptionErr <- data
+		},
+		OnUnsubscribed: func(pdu.UnsubscribeBodyResponse) {
+			unsubscribed <- true
+		},
+	})
+	go client.Start()
+	<-subscribed
+
+	srv.SubscriptionInfo(channel, "fast_forward", "Subscription was fast-forwarded")
+	select {
+	case data := <-info:
+		if data.Info != "fast_forward" || data.SubscriptionId != channel {
+			t.Fatal("Wrong subscription info:", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnSubscriptionInfo is not called")
+	}
+
+	srv.SubscriptionError(channel, "expired_position", "Position is expired")
+	select {
+	case data := <-subscriptionErr:
+		if data.Error != "expired_position" || data.SubscriptionId != channel {
+			t.Fatal("Wrong subscription error:", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnSubscriptionError is not called")
+	}
+	select {
+	case <-unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscription is not marked as unsubscribed after the error")
+	}
+}
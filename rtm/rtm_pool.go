@@ -0,0 +1,357 @@
+package rtm
+
+import (
+	"context"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/observer"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Number of points every client takes on the hash ring. More points spread channels more evenly
+const poolVirtualNodes = 128
+
+var (
+	ERROR_INVALID_POOL_SIZE = errors.New("Pool size must be positive")
+)
+
+// Pool opens several connections to the same endpoint and spreads the load between them.
+//
+// Every channel is assigned to one of the clients by consistent hash of the channel name
+// (the subscription id for subscriptions), so all requests to the channel go through the same connection
+// and keep their order. Pool has the same request API as RTMClient.
+//
+// Pool fires aggregated events:
+//   EVENT_CONNECTED       - all clients are connected
+//   EVENT_LEAVE_CONNECTED - one of the clients is disconnected after all clients were connected
+//   EVENT_STOPPED         - all clients are stopped
+//   EVENT_LEAVE_STOPPED   - one of the clients is started after all clients were stopped
+//   EVENT_ERROR, EVENT_AUTHENTICATED and EVENT_GIVE_UP are fired when any of the clients fires them
+type Pool struct {
+	clients []*RTMClient
+	ring    []poolNode
+
+	mutex     sync.Mutex
+	connected int
+	stopped   int
+
+	// Implements Observer behavior
+	observer.Observer
+}
+
+type poolNode struct {
+	hash   uint32
+	client int
+}
+
+// Creates a pool of "size" clients. Options are applied to every client.
+//
+// You should run Start() after creating a new pool to establish connections to RTM
+func NewPool(endpoint, appkey string, size int, opts Options) (*Pool, error) {
+	if size <= 0 {
+		return nil, RTMError{
+			Code:   ERROR_CODE_APPLICATION,
+			Reason: ERROR_INVALID_POOL_SIZE,
+		}
+	}
+
+	p := &Pool{
+		Observer: observer.New(),
+		stopped:  size,
+	}
+	for i := 0; i < size; i++ {
+		client, err := New(endpoint, appkey, opts)
+		if err != nil {
+			return nil, err
+		}
+		p.clients = append(p.clients, client)
+		p.watch(client, size)
+
+		for v := 0; v < poolVirtualNodes; v++ {
+			p.ring = append(p.ring, poolNode{
+				hash:   hashKey(strconv.Itoa(i) + "-" + strconv.Itoa(v)),
+				client: i,
+			})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+
+	return p, nil
+}
+
+// Aggregates the client events
+func (p *Pool) watch(client *RTMClient, size int) {
+	client.On(EVENT_CONNECTED, func(interface{}) {
+		p.mutex.Lock()
+		p.connected++
+		all := p.connected == size
+		p.mutex.Unlock()
+		if all {
+			p.Fire(EVENT_CONNECTED, nil)
+		}
+	})
+	client.On(EVENT_LEAVE_CONNECTED, func(interface{}) {
+		p.mutex.Lock()
+		all := p.connected == size
+		p.connected--
+		p.mutex.Unlock()
+		if all {
+			p.Fire(EVENT_LEAVE_CONNECTED, nil)
+		}
+	})
+	client.On(EVENT_STOPPED, func(interface{}) {
+		p.mutex.Lock()
+		p.stopped++
+		all := p.stopped == size
+		p.mutex.Unlock()
+		if all {
+			p.Fire(EVENT_STOPPED, nil)
+		}
+	})
+	client.On(EVENT_LEAVE_STOPPED, func(interface{}) {
+		p.mutex.Lock()
+		all := p.stopped == size
+		p.stopped--
+		p.mutex.Unlock()
+		if all {
+			p.Fire(EVENT_LEAVE_STOPPED, nil)
+		}
+	})
+	for _, event := range []string{EVENT_ERROR, EVENT_AUTHENTICATED, EVENT_GIVE_UP} {
+		func(event string) {
+			client.On(event, func(data interface{}) {
+				p.Fire(event, data)
+			})
+		}(event)
+	}
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Returns the client that serves the channel or the subscription id
+func (p *Pool) Client(channel string) *RTMClient {
+	hash := hashKey(channel)
+	i := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= hash
+	})
+	if i == len(p.ring) {
+		i = 0
+	}
+	return p.clients[p.ring[i].client]
+}
+
+// Returns all clients of the pool
+func (p *Pool) Clients() []*RTMClient {
+	clients := make([]*RTMClient, len(p.clients))
+	copy(clients, p.clients)
+	return clients
+}
+
+// Starts all clients
+func (p *Pool) Start() {
+	for _, client := range p.clients {
+		client.Start()
+	}
+}
+
+// Stops all clients
+func (p *Pool) Stop() {
+	for _, client := range p.clients {
+		client.Stop()
+	}
+}
+
+// Closes all clients and releases the resources of the pool. Check RTMClient.Close
+func (p *Pool) Close() {
+	for _, client := range p.clients {
+		client.Close()
+	}
+	p.Observer.Close()
+}
+
+// Checks if all clients are connected
+func (p *Pool) IsConnected() bool {
+	for _, client := range p.clients {
+		if !client.IsConnected() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Pool) Publish(channel string, message interface{}) error {
+	return p.Client(channel).Publish(channel, message)
+}
+
+func (p *Pool) PublishCtx(ctx context.Context, channel string, message interface{}) error {
+	return p.Client(channel).PublishCtx(ctx, channel, message)
+}
+
+func (p *Pool) PublishAck(channel string, message interface{}) <-chan PublishResponse {
+	return p.Client(channel).PublishAck(channel, message)
+}
+
+func (p *Pool) PublishAckCtx(ctx context.Context, channel string, message interface{}) <-chan PublishResponse {
+	return p.Client(channel).PublishAckCtx(ctx, channel, message)
+}
+
+func (p *Pool) PublishWithOptions(channel string, message interface{}, opts pdu.PublishBodyOpts) error {
+	return p.Client(channel).PublishWithOptions(channel, message, opts)
+}
+
+func (p *Pool) PublishWithOptionsCtx(ctx context.Context, channel string, message interface{}, opts pdu.PublishBodyOpts) error {
+	return p.Client(channel).PublishWithOptionsCtx(ctx, channel, message, opts)
+}
+
+func (p *Pool) PublishAckWithOptions(channel string, message interface{}, opts pdu.PublishBodyOpts) <-chan PublishResponse {
+	return p.Client(channel).PublishAckWithOptions(channel, message, opts)
+}
+
+func (p *Pool) PublishAckWithOptionsCtx(ctx context.Context, channel string, message interface{}, opts pdu.PublishBodyOpts) <-chan PublishResponse {
+	return p.Client(channel).PublishAckWithOptionsCtx(ctx, channel, message, opts)
+}
+
+func (p *Pool) Write(channel string, message interface{}) <-chan WriteResponse {
+	return p.Client(channel).Write(channel, message)
+}
+
+func (p *Pool) WriteCtx(ctx context.Context, channel string, message interface{}) <-chan WriteResponse {
+	return p.Client(channel).WriteCtx(ctx, channel, message)
+}
+
+func (p *Pool) Delete(channel string) <-chan DeleteResponse {
+	return p.Client(channel).Delete(channel)
+}
+
+func (p *Pool) DeleteCtx(ctx context.Context, channel string) <-chan DeleteResponse {
+	return p.Client(channel).DeleteCtx(ctx, channel)
+}
+
+func (p *Pool) DeleteWithOptions(channel string, opts pdu.DeleteBodyOpts) <-chan DeleteResponse {
+	return p.Client(channel).DeleteWithOptions(channel, opts)
+}
+
+func (p *Pool) DeleteWithOptionsCtx(ctx context.Context, channel string, opts pdu.DeleteBodyOpts) <-chan DeleteResponse {
+	return p.Client(channel).DeleteWithOptionsCtx(ctx, channel, opts)
+}
+
+func (p *Pool) Read(channel string) <-chan ReadResponse {
+	return p.Client(channel).Read(channel)
+}
+
+func (p *Pool) ReadCtx(ctx context.Context, channel string) <-chan ReadResponse {
+	return p.Client(channel).ReadCtx(ctx, channel)
+}
+
+func (p *Pool) ReadPos(channel string, position string) <-chan ReadResponse {
+	return p.Client(channel).ReadPos(channel, position)
+}
+
+func (p *Pool) ReadPosCtx(ctx context.Context, channel string, position string) <-chan ReadResponse {
+	return p.Client(channel).ReadPosCtx(ctx, channel, position)
+}
+
+// Creates a subscription on the client that serves the subscription id. Check RTMClient.Subscribe
+// to get information about the params
+func (p *Pool) Subscribe(subscriptionId string, mode subscription.Mode, opts pdu.SubscribeBodyOpts, listener subscription.Listener) error {
+	return p.Client(subscriptionId).Subscribe(subscriptionId, mode, opts, listener)
+}
+
+func (p *Pool) SubscribeCtx(ctx context.Context, subscriptionId string, mode subscription.Mode, opts pdu.SubscribeBodyOpts, listener subscription.Listener) error {
+	return p.Client(subscriptionId).SubscribeCtx(ctx, subscriptionId, mode, opts, listener)
+}
+
+func (p *Pool) Unsubscribe(subscriptionId string) <-chan UnsunscribeResponse {
+	return p.Client(subscriptionId).Unsubscribe(subscriptionId)
+}
+
+func (p *Pool) UnsubscribeCtx(ctx context.Context, subscriptionId string) <-chan UnsunscribeResponse {
+	return p.Client(subscriptionId).UnsubscribeCtx(ctx, subscriptionId)
+}
+
+func (p *Pool) GetSubscription(subscriptionId string) (*subscription.Subscription, error) {
+	return p.Client(subscriptionId).GetSubscription(subscriptionId)
+}
+
+// EVENTS
+
+func (p *Pool) OnConnected(callback func()) interface{} {
+	return p.On(EVENT_CONNECTED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnConnectedOnce(callback func()) {
+	p.Once(EVENT_CONNECTED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnLeaveConnected(callback func()) interface{} {
+	return p.On(EVENT_LEAVE_CONNECTED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnLeaveConnectedOnce(callback func()) {
+	p.Once(EVENT_LEAVE_CONNECTED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnStopped(callback func()) interface{} {
+	return p.On(EVENT_STOPPED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnStoppedOnce(callback func()) {
+	p.Once(EVENT_STOPPED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnLeaveStopped(callback func()) interface{} {
+	return p.On(EVENT_LEAVE_STOPPED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnLeaveStoppedOnce(callback func()) {
+	p.Once(EVENT_LEAVE_STOPPED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnError(callback func(err RTMError)) interface{} {
+	return p.On(EVENT_ERROR, func(data interface{}) {
+		callback(data.(RTMError))
+	})
+}
+func (p *Pool) OnErrorOnce(callback func(err RTMError)) {
+	p.Once(EVENT_ERROR, func(data interface{}) {
+		callback(data.(RTMError))
+	})
+}
+func (p *Pool) OnAuthenticated(callback func()) interface{} {
+	return p.On(EVENT_AUTHENTICATED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnAuthenticatedOnce(callback func()) {
+	p.Once(EVENT_AUTHENTICATED, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnGiveUp(callback func()) interface{} {
+	return p.On(EVENT_GIVE_UP, func(data interface{}) {
+		callback()
+	})
+}
+func (p *Pool) OnGiveUpOnce(callback func()) {
+	p.Once(EVENT_GIVE_UP, func(data interface{}) {
+		callback()
+	})
+}
@@ -0,0 +1,147 @@
+package rtm
+
+import (
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPool_InvalidSize(t *testing.T) {
+	if _, err := NewPool("ws://some-host-name.www", "123", 0, Options{}); err.(RTMError).Reason != ERROR_INVALID_POOL_SIZE {
+		t.Fatal("Wrong error returned:", err)
+	}
+}
+
+func TestPool_ConsistentHash(t *testing.T) {
+	pool, _ := NewPool("ws://some-host-name.www", "123", 4, Options{})
+
+	counts := make(map[*RTMClient]int)
+	for i := 0; i < 4000; i++ {
+		channel := "channel-" + strconv.Itoa(i)
+		client := pool.Client(channel)
+		if pool.Client(channel) != client {
+			t.Fatal("Channel is assigned to different clients")
+		}
+		counts[client]++
+	}
+	for _, client := range pool.Clients() {
+		if counts[client] < 500 {
+			t.Fatal("Channels are not spread evenly:", counts[client])
+		}
+	}
+
+	// Most of the channels stay on the same client when the pool grows
+	larger, _ := NewPool("ws://some-host-name.www", "123", 5, Options{})
+	moved := 0
+	for i := 0; i < 4000; i++ {
+		channel := "channel-" + strconv.Itoa(i)
+		if indexOf(pool.Clients(), pool.Client(channel)) != indexOf(larger.Clients(), larger.Client(channel)) {
+			moved++
+		}
+	}
+	if moved > 1400 {
+		t.Fatal("Too many channels moved:", moved)
+	}
+}
+
+func indexOf(clients []*RTMClient, client *RTMClient) int {
+	for i, c := range clients {
+		if c == client {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestLocal_Pool(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	pool, err := NewPool(srv.URL, "local-appkey", 3, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected := make(chan bool, 10)
+	stopped := make(chan bool, 10)
+	pool.OnConnected(func() {
+		connected <- true
+	})
+	pool.OnStopped(func() {
+		stopped <- true
+	})
+
+	messages := make(chan string, 100)
+	subscribed := make(chan bool, 100)
+	var channels []string
+	for i := 0; i < 10; i++ {
+		channel := getChannel() + strconv.Itoa(i)
+		channels = append(channels, channel)
+		pool.Subscribe(channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+			OnSubscribed: func(pdu.SubscribeOk) {
+				subscribed <- true
+			},
+			OnData: func(data pdu.SubscriptionData) {
+				for _, message := range data.Messages {
+					messages <- data.SubscriptionId + ":" + string(message)
+				}
+			},
+		})
+	}
+
+	pool.Start()
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pool is not connected")
+	}
+	if !pool.IsConnected() || len(srv.Connections()) != 3 {
+		t.Fatal("Wrong number of connections:", len(srv.Connections()))
+	}
+	for range channels {
+		select {
+		case <-subscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Unable to subscribe")
+		}
+	}
+
+	for _, channel := range channels {
+		if _, err := pool.Client(channel).GetSubscription(channel); err != nil {
+			t.Fatal("Subscription is created on the wrong client")
+		}
+		if response := <-pool.PublishAck(channel, "hello"); response.Err != nil {
+			t.Fatal(response.Err)
+		}
+	}
+
+	received := make(map[string]bool)
+	for range channels {
+		select {
+		case message := <-messages:
+			received[message] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("Message is not received")
+		}
+	}
+	for _, channel := range channels {
+		if !received[channel+`:"hello"`] {
+			t.Fatal("Message is not received from", channel)
+		}
+	}
+
+	pool.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pool is not stopped")
+	}
+	select {
+	case <-stopped:
+		t.Fatal("EVENT_STOPPED is fired more than once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,333 @@
+package rtm
+
+import (
+	"context"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/logger"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"sync"
+	"time"
+)
+
+// Defines what happens when the offline queue is full
+type QueuePolicy int
+
+const (
+	// Drops the oldest queued request. Its response channel receives ERROR_QUEUE_OVERFLOW
+	QUEUE_DROP_OLDEST QueuePolicy = iota
+
+	// Rejects the new request with ERROR_QUEUE_OVERFLOW
+	QUEUE_DROP_NEWEST
+
+	// Blocks the caller until there is space in the queue or the context is done
+	QUEUE_BLOCK
+)
+
+var (
+	ERROR_QUEUE_OVERFLOW = errors.New("Offline queue is full")
+	ERROR_QUEUE_EXPIRED  = errors.New("Request expired in the offline queue")
+)
+
+// Request held in the offline queue
+type queuedRequest struct {
+	ctx      context.Context
+	action   string
+	body     interface{}
+	ack      bool
+	queuedAt time.Time
+
+	// Receives exactly one Ack for requests with acknowledge. Nil for requests without acknowledge
+	result chan connection.Ack
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Completes the request. Only the first call has effect
+func (r *queuedRequest) complete(ack connection.Ack) {
+	r.once.Do(func() {
+		close(r.done)
+		if r.result != nil {
+			r.result <- ack
+			close(r.result)
+		}
+	})
+}
+
+func (r *queuedRequest) fail(reason error) {
+	if r.result == nil {
+		logger.Warn("Offline queue: Dropped", r.action, "request:", reason)
+	}
+	r.complete(connection.Ack{
+		Err: RTMError{
+			Code:   ERROR_CODE_APPLICATION,
+			Reason: reason,
+		},
+	})
+}
+
+func (r *queuedRequest) isDone() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+type offlineQueue struct {
+	size   int
+	maxAge time.Duration
+	policy QueuePolicy
+
+	mutex    sync.Mutex
+	items    []*queuedRequest
+	flushing bool
+
+	// Incremented every time the queue is failed, e.g. when the client is stopped. The flush goroutine
+	// started before must not put requests back into the queue
+	generation int
+	failReason error
+
+	// Closed and replaced every time the queue shrinks. Wakes up blocked callers
+	changed chan struct{}
+}
+
+func newOfflineQueue(opts Options) *offlineQueue {
+	if opts.OfflineQueueSize <= 0 {
+		return nil
+	}
+	return &offlineQueue{
+		size:    opts.OfflineQueueSize,
+		maxAge:  opts.OfflineQueueMaxAge,
+		policy:  opts.OfflineQueuePolicy,
+		changed: make(chan struct{}),
+	}
+}
+
+// Only publish and write requests are queued: the order of other requests depends on the connection state
+func isQueueable(action string) bool {
+	return action == "rtm/publish" || action == "rtm/write"
+}
+
+// Queues the request if the client is not connected or the queue is being flushed.
+// Returns false if the request should be sent directly.
+func (rtm *RTMClient) enqueue(ctx context.Context, action string, body interface{}, ack bool) (<-chan connection.Ack, bool, error) {
+	q := rtm.offlineQueue
+	request := &queuedRequest{
+		ctx:    ctx,
+		action: action,
+		body:   body,
+		ack:    ack,
+		done:   make(chan struct{}),
+	}
+	if ack {
+		request.result = make(chan connection.Ack, 1)
+	}
+
+	q.mutex.Lock()
+	for {
+		if len(q.items) == 0 && !q.flushing && rtm.IsConnected() {
+			q.mutex.Unlock()
+			return nil, false, nil
+		}
+
+		q.removeExpired()
+		if len(q.items) < q.size {
+			break
+		}
+
+		switch q.policy {
+		case QUEUE_DROP_NEWEST:
+			q.mutex.Unlock()
+			return nil, true, RTMError{
+				Code:   ERROR_CODE_APPLICATION,
+				Reason: ERROR_QUEUE_OVERFLOW,
+			}
+		case QUEUE_BLOCK:
+			changed := q.changed
+			q.mutex.Unlock()
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return nil, true, RTMError{
+					Code:   ERROR_CODE_CONTEXT,
+					Reason: ctx.Err(),
+				}
+			}
+			q.mutex.Lock()
+		default:
+			q.items[0].fail(ERROR_QUEUE_OVERFLOW)
+			q.items = q.items[1:]
+		}
+	}
+
+	request.queuedAt = time.Now()
+	q.items = append(q.items, request)
+	q.mutex.Unlock()
+
+	if ack && ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				request.complete(connection.Ack{Err: ctx.Err()})
+			case <-request.done:
+			}
+		}()
+	}
+
+	return request.result, true, nil
+}
+
+// Removes the completed and expired requests from the whole queue: requests can have different deadlines.
+// Must be called with the mutex locked
+func (q *offlineQueue) removeExpired() {
+	items := q.items[:0]
+	for _, request := range q.items {
+		switch {
+		case request.isDone():
+		case request.ctx.Err() != nil:
+			request.complete(connection.Ack{Err: request.ctx.Err()})
+		case q.isExpired(request):
+			request.fail(ERROR_QUEUE_EXPIRED)
+		default:
+			items = append(items, request)
+			continue
+		}
+	}
+	if len(items) == len(q.items) {
+		return
+	}
+	for i := len(items); i < len(q.items); i++ {
+		q.items[i] = nil
+	}
+	q.items = items
+	q.notify()
+}
+
+// Puts the request back at the head of the queue. Fails the request instead if the queue was failed
+// after the flush started. Must be called with the mutex locked
+func (q *offlineQueue) putBack(request *queuedRequest, generation int) {
+	if q.generation != generation {
+		request.fail(q.failReason)
+		return
+	}
+	q.items = append([]*queuedRequest{request}, q.items...)
+	q.notify()
+}
+
+func (q *offlineQueue) isExpired(request *queuedRequest) bool {
+	return q.maxAge > 0 && time.Since(request.queuedAt) > q.maxAge
+}
+
+// Wakes up callers blocked on the full queue. Must be called with the mutex locked
+func (q *offlineQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Sends the queued requests in order. Stops if the client is disconnected, the rest of the requests
+// stay in the queue until the client connects again
+func (rtm *RTMClient) flushOfflineQueue() {
+	q := rtm.offlineQueue
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	if q.flushing || len(q.items) == 0 {
+		q.mutex.Unlock()
+		return
+	}
+	q.flushing = true
+	generation := q.generation
+	q.mutex.Unlock()
+
+	logger.Info("Offline queue: Flushing queued requests")
+	go func() {
+		for {
+			q.mutex.Lock()
+			if q.generation != generation {
+				q.mutex.Unlock()
+				return
+			}
+			if len(q.items) == 0 || !rtm.IsConnected() {
+				q.flushing = false
+				q.notify()
+				q.mutex.Unlock()
+				return
+			}
+			request := q.items[0]
+			q.items = q.items[1:]
+			q.notify()
+			q.mutex.Unlock()
+
+			if request.isDone() {
+				continue
+			}
+			if err := request.ctx.Err(); err != nil {
+				request.complete(connection.Ack{Err: err})
+				continue
+			}
+			if q.isExpired(request) {
+				request.fail(ERROR_QUEUE_EXPIRED)
+				continue
+			}
+
+			_, _, completed := rtm.requests.state()
+			ch, err := rtm.sendNow(request.ctx, request.action, request.body, request.ack)
+			if err != nil {
+				if rtmErr, ok := err.(RTMError); ok && rtmErr.Code == ERROR_CODE_INVALID_JSON {
+					request.complete(connection.Ack{Err: err})
+					continue
+				}
+				if rtmErr, ok := err.(RTMError); ok && rtmErr.Reason == connection.ERROR_TOO_MANY_IN_FLIGHT {
+					// Options.MaxInFlight is reached. Wait for a response and send the request again
+					select {
+					case <-completed:
+					case <-request.done:
+					}
+					q.mutex.Lock()
+					q.putBack(request, generation)
+					q.mutex.Unlock()
+					continue
+				}
+
+				// The connection is broken. Keep the request to send it after reconnecting
+				q.mutex.Lock()
+				q.putBack(request, generation)
+				if q.generation == generation {
+					q.flushing = false
+				}
+				q.mutex.Unlock()
+				return
+			}
+
+			if request.ack {
+				go func() {
+					request.complete(<-ch)
+				}()
+			} else {
+				request.complete(connection.Ack{})
+			}
+		}
+	}()
+}
+
+// Fails all queued requests, e.g. when the client is stopped
+func (rtm *RTMClient) failOfflineQueue(reason error) {
+	q := rtm.offlineQueue
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, request := range q.items {
+		request.fail(reason)
+	}
+	q.items = nil
+	q.flushing = false
+	q.generation++
+	q.failReason = reason
+	q.notify()
+}
@@ -0,0 +1,316 @@
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func queueErrorReason(err error) error {
+	if rtmErr, ok := err.(RTMError); ok {
+		return rtmErr.Reason
+	}
+	return nil
+}
+
+func TestLocal_OfflineQueue_Flush(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	var mutex sync.Mutex
+	var received []string
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		var body pdu.PublishBody
+		json.Unmarshal(query.Body, &body)
+		mutex.Lock()
+		received = append(received, body.Message.(string))
+		position := strconv.Itoa(len(received))
+		mutex.Unlock()
+		conn.Reply(query, "ok", pdu.PublishBodyResponse{Position: position})
+	})
+
+	client := getLocalRTM(srv, Options{
+		OfflineQueueSize: 10,
+	})
+	defer client.Stop()
+
+	channel := getChannel()
+	var responses []<-chan PublishResponse
+	for i := 0; i < 3; i++ {
+		responses = append(responses, client.PublishAck(channel, "ack-"+strconv.Itoa(i)))
+	}
+	if err := client.Publish(channel, "noack"); err != nil {
+		t.Fatal("Publish is not queued:", err)
+	}
+	write := client.Write(channel, "value")
+
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ch := range responses {
+		select {
+		case response := <-ch:
+			if response.Err != nil || response.Response.Position != strconv.Itoa(i+1) {
+				t.Fatal("Wrong response to the queued request:", response)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Queued request is not acknowledged")
+		}
+	}
+	if response := <-write; response.Err != nil {
+		t.Fatal(response.Err)
+	}
+
+	// New requests are sent after the queued ones
+	if response := <-client.PublishAck(channel, "after"); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"ack-0", "ack-1", "ack-2", "noack", "after"}
+	for i := range expected {
+		if i >= len(received) || received[i] != expected[i] {
+			t.Fatal("Wrong order of requests:", received)
+		}
+	}
+
+	read := <-client.Read(channel)
+	if string(read.Response.Message) != `"value"` {
+		t.Fatal("Queued write is not sent")
+	}
+}
+
+func TestLocal_OfflineQueue_DropOldest(t *testing.T) {
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{
+		OfflineQueueSize: 2,
+	})
+
+	first := client.PublishAck("channel", 1)
+	client.PublishAck("channel", 2)
+	client.PublishAck("channel", 3)
+
+	select {
+	case response := <-first:
+		if queueErrorReason(response.Err) != ERROR_QUEUE_OVERFLOW {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The oldest request is not dropped")
+	}
+}
+
+func TestLocal_OfflineQueue_DropNewest(t *testing.T) {
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{
+		OfflineQueueSize:   1,
+		OfflineQueuePolicy: QUEUE_DROP_NEWEST,
+	})
+
+	if err := client.Publish("channel", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Publish("channel", 2); queueErrorReason(err) != ERROR_QUEUE_OVERFLOW {
+		t.Fatal("Wrong error returned:", err)
+	}
+	response := <-client.Write("channel", 3)
+	if queueErrorReason(response.Err) != ERROR_QUEUE_OVERFLOW {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+}
+
+func TestLocal_OfflineQueue_ExpiredBehindLive(t *testing.T) {
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{
+		OfflineQueueSize:   2,
+		OfflineQueuePolicy: QUEUE_DROP_NEWEST,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.Publish("channel", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PublishCtx(ctx, "channel", 2); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// The canceled request is behind the live one, but it is removed to free the space
+	if err := client.Publish("channel", 3); err != nil {
+		t.Fatal("Canceled request is not removed from the queue:", err)
+	}
+	if size, _ := client.offlineQueue.state(); size != 2 {
+		t.Fatal("Wrong queue size:", size)
+	}
+}
+
+func TestOfflineQueue_PutBackAfterFail(t *testing.T) {
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{
+		OfflineQueueSize: 10,
+	})
+	q := client.offlineQueue
+
+	live := &queuedRequest{ctx: context.Background(), done: make(chan struct{}), result: make(chan connection.Ack, 1)}
+	_, changed := q.state()
+	q.mutex.Lock()
+	q.putBack(live, q.generation)
+	q.mutex.Unlock()
+	select {
+	case <-changed:
+	default:
+		t.Fatal("Blocked callers are not woken up")
+	}
+
+	// The flush started before the queue was failed must not resurrect the request
+	q.mutex.Lock()
+	generation := q.generation
+	q.mutex.Unlock()
+	client.failOfflineQueue(ERROR_NOT_CONNECTED)
+
+	failed := &queuedRequest{ctx: context.Background(), done: make(chan struct{}), result: make(chan connection.Ack, 1)}
+	q.mutex.Lock()
+	q.putBack(failed, generation)
+	q.mutex.Unlock()
+	if size, _ := q.state(); size != 0 {
+		t.Fatal("Request is put back into the failed queue")
+	}
+	if ack := <-failed.result; queueErrorReason(ack.Err) != ERROR_NOT_CONNECTED {
+		t.Fatal("Wrong error returned:", ack.Err)
+	}
+}
+
+func TestLocal_OfflineQueue_Block(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		OfflineQueueSize:   1,
+		OfflineQueuePolicy: QUEUE_BLOCK,
+	})
+	defer client.Stop()
+
+	first := client.PublishAck(getChannel(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	response := <-client.PublishAckCtx(ctx, getChannel(), 2)
+	if rtmErr, ok := response.Err.(RTMError); !ok || rtmErr.Code != ERROR_CODE_CONTEXT {
+		t.Fatal("Blocked request did not return after the deadline:", response.Err)
+	}
+
+	blocked := make(chan PublishResponse, 1)
+	go func() {
+		blocked <- <-client.PublishAck(getChannel(), 3)
+	}()
+
+	go client.Start()
+	for _, ch := range []<-chan PublishResponse{first, blocked} {
+		select {
+		case response := <-ch:
+			if response.Err != nil {
+				t.Fatal(response.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Queued request is not sent after connecting")
+		}
+	}
+}
+
+func TestLocal_OfflineQueue_Expired(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		OfflineQueueSize:   10,
+		OfflineQueueMaxAge: 10 * time.Millisecond,
+	})
+	defer client.Stop()
+
+	expired := client.PublishAck(getChannel(), 1)
+	time.Sleep(50 * time.Millisecond)
+
+	go client.Start()
+	response := <-expired
+	if queueErrorReason(response.Err) != ERROR_QUEUE_EXPIRED {
+		t.Fatal("Wrong error returned:", response.Err)
+	}
+}
+
+func TestLocal_OfflineQueue_CtxCanceled(t *testing.T) {
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{
+		OfflineQueueSize: 10,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := client.WriteCtx(ctx, "channel", 1)
+	cancel()
+
+	select {
+	case response := <-ch:
+		if rtmErr, ok := response.Err.(RTMError); !ok || rtmErr.Code != ERROR_CODE_CONTEXT {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queued request is not canceled")
+	}
+}
+
+func TestLocal_OfflineQueue_GiveUp(t *testing.T) {
+	srv := rtmtest.NewServer()
+	srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		OfflineQueueSize: 10,
+		ReconnectPolicy:  NeverReconnect{},
+	})
+
+	ch := client.PublishAck("channel", 1)
+	go client.Start()
+
+	select {
+	case response := <-ch:
+		if queueErrorReason(response.Err) != ERROR_NOT_CONNECTED {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queued request did not fail after the client stopped")
+	}
+}
+
+func TestLocal_OfflineQueue_MaxInFlight(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		OfflineQueueSize: 10,
+		MaxInFlight:      2,
+	})
+	defer client.Stop()
+
+	channel := getChannel()
+	var responses []<-chan PublishResponse
+	for i := 0; i < 4; i++ {
+		responses = append(responses, client.PublishAck(channel, i))
+	}
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	responses = append(responses, client.PublishAck(channel, "after"))
+
+	for _, ch := range responses {
+		select {
+		case response := <-ch:
+			if response.Err != nil {
+				t.Fatal("Queued request failed:", response.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Queued request is not sent when MaxInFlight is reached")
+		}
+	}
+}
@@ -156,6 +156,9 @@ func TestMultipleSubscription(t *testing.T) {
 	}()
 
 	wg.Wait()
+	if errorOccured {
+		t.Fatal("Subscription events did not occur")
+	}
 
 	// Check the current subscription. Should be the subscription with filter
 	sub, _ := client.GetSubscription(channel)
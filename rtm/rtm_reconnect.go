@@ -0,0 +1,133 @@
+package rtm
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Defines how the client reconnects after the connection is broken or the client failed to connect.
+//
+// NextDelay is called every time the client enters STATE_AWAITING. Attempt is the number of
+// reconnect attempts made since the client was connected last time (starts from 0). Previous is the delay
+// returned for the previous attempt (0 for the first attempt).
+//
+// Return false to give up reconnecting. In this case the client goes to STATE_STOPPED and fires
+// the EVENT_GIVE_UP event.
+type ReconnectPolicy interface {
+	NextDelay(attempt int, previous time.Duration) (time.Duration, bool)
+}
+
+// Reconnects immediately on the first attempt, then waits Base * 2^(attempt-1), but not more than Max.
+// Adds up to 100 ms jitter.
+// Base defaults to 1 second, Max defaults to MAX_RECONNECT_TIME_SEC seconds.
+type ExponentialReconnect struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (p ExponentialReconnect) NextDelay(attempt int, previous time.Duration) (time.Duration, bool) {
+	base, max := reconnectLimits(p.Base, p.Max)
+	if attempt == 0 {
+		return jitter(0), true
+	}
+
+	// Compare without shifting base: base << shift overflows long before the attempt limit
+	delay := max
+	if shift := uint(attempt - 1); shift < 63 && base <= (max-1)>>shift {
+		delay = base << shift
+	}
+	return jitter(delay), true
+}
+
+// Reconnects immediately on the first attempt, then waits a random time between Base and
+// 3 times the previous delay, but not more than Max.
+// Spreads reconnects of many clients better than the exponential backoff.
+// Base defaults to 1 second, Max defaults to MAX_RECONNECT_TIME_SEC seconds.
+//
+// Check https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/ to get more information.
+type DecorrelatedJitterReconnect struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (p DecorrelatedJitterReconnect) NextDelay(attempt int, previous time.Duration) (time.Duration, bool) {
+	base, max := reconnectLimits(p.Base, p.Max)
+	if attempt == 0 {
+		return jitter(0), true
+	}
+
+	if previous < base {
+		previous = base
+	}
+	if previous > max {
+		previous = max
+	}
+
+	// Compare without multiplying previous: previous * 3 overflows for large Max
+	upper := max
+	if previous <= max/3 {
+		upper = previous * 3
+	}
+	delay := base
+	if upper > base {
+		delay += time.Duration(rand.Int63n(int64(upper-base) + 1))
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay, true
+}
+
+// Waits the same Delay before every reconnect attempt
+type ConstantReconnect struct {
+	Delay time.Duration
+}
+
+func (p ConstantReconnect) NextDelay(attempt int, previous time.Duration) (time.Duration, bool) {
+	return p.Delay, true
+}
+
+// Never reconnects. The client stops as soon as the connection is broken
+type NeverReconnect struct{}
+
+func (p NeverReconnect) NextDelay(attempt int, previous time.Duration) (time.Duration, bool) {
+	return 0, false
+}
+
+// Default policy: waits attempt^2 seconds, but not more than MAX_RECONNECT_TIME_SEC. Adds up to 100 ms jitter
+type defaultReconnect struct{}
+
+func (p defaultReconnect) NextDelay(attempt int, previous time.Duration) (time.Duration, bool) {
+	reconnect_sec := attempt * attempt
+	if reconnect_sec > MAX_RECONNECT_TIME_SEC {
+		reconnect_sec = MAX_RECONNECT_TIME_SEC
+	}
+	return jitter(time.Duration(reconnect_sec) * time.Second), true
+}
+
+func reconnectLimits(base, max time.Duration) (time.Duration, time.Duration) {
+	if base <= 0 {
+		base = time.Second
+	}
+	if max <= 0 {
+		max = MAX_RECONNECT_TIME_SEC * time.Second
+	}
+	return base, max
+}
+
+func jitter(delay time.Duration) time.Duration {
+	return delay + time.Duration(rand.Intn(100))*time.Millisecond
+}
+
+// Gets the delay before the next reconnect attempt. Returns false if the client should give up reconnecting
+func (rtm *RTMClient) nextReconnectInterval() (time.Duration, bool) {
+	if rtm.opts.MaxReconnectAttempts > 0 && rtm.reconnectCount >= rtm.opts.MaxReconnectAttempts {
+		return 0, false
+	}
+
+	policy := rtm.opts.ReconnectPolicy
+	if policy == nil {
+		policy = defaultReconnect{}
+	}
+	return policy.NextDelay(rtm.reconnectCount, rtm.lastReconnectDelay)
+}
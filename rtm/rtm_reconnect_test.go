@@ -0,0 +1,184 @@
+package rtm
+
+import (
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDefaultReconnect(t *testing.T) {
+	client, _ := New("ws://some-host-name.www", "123", Options{})
+
+	for attempt, expected := range []int{0, 1, 4, 9} {
+		client.reconnectCount = attempt
+		delay, ok := client.nextReconnectInterval()
+		if !ok {
+			t.Fatal("Default policy gave up reconnecting")
+		}
+		min := time.Duration(expected) * time.Second
+		if delay < min || delay > min+100*time.Millisecond {
+			t.Fatalf("Wrong delay for attempt %d: %s", attempt, delay)
+		}
+	}
+
+	client.reconnectCount = 1000
+	if delay, _ := client.nextReconnectInterval(); delay > (MAX_RECONNECT_TIME_SEC*time.Second + 100*time.Millisecond) {
+		t.Fatal("Delay exceeds MAX_RECONNECT_TIME_SEC:", delay)
+	}
+}
+
+func TestExponentialReconnect(t *testing.T) {
+	policy := ExponentialReconnect{
+		Base: 100 * time.Millisecond,
+		Max:  time.Second,
+	}
+	expected := []time.Duration{0, 100, 200, 400, 800, 1000, 1000}
+	for attempt, e := range expected {
+		delay, ok := policy.NextDelay(attempt, 0)
+		min := e * time.Millisecond
+		if !ok || delay < min || delay > min+100*time.Millisecond {
+			t.Fatalf("Wrong delay for attempt %d: %s", attempt, delay)
+		}
+	}
+
+	if delay, _ := policy.NextDelay(1000, 0); delay > 1100*time.Millisecond {
+		t.Fatal("Delay overflow:", delay)
+	}
+	// Base << attempt overflows int64 before it reaches Max
+	policy = ExponentialReconnect{
+		Base: 10 * time.Second,
+		Max:  100000 * time.Hour,
+	}
+	for attempt := 28; attempt <= 31; attempt++ {
+		if delay, _ := policy.NextDelay(attempt, 0); delay < policy.Max {
+			t.Fatalf("Delay overflow for attempt %d: %s", attempt, delay)
+		}
+	}
+}
+
+func TestDecorrelatedJitterReconnect(t *testing.T) {
+	policy := DecorrelatedJitterReconnect{
+		Base: 100 * time.Millisecond,
+		Max:  time.Second,
+	}
+	var previous time.Duration
+	for attempt := 0; attempt < 100; attempt++ {
+		delay, ok := policy.NextDelay(attempt, previous)
+		if !ok {
+			t.Fatal("Policy gave up reconnecting")
+		}
+		if attempt > 0 {
+			upper := previous * 3
+			if upper < policy.Base*3 {
+				upper = policy.Base * 3
+			}
+			if delay < policy.Base || delay > policy.Max || delay > upper {
+				t.Fatalf("Wrong delay for attempt %d: %s (previous %s)", attempt, delay, previous)
+			}
+		}
+		previous = delay
+	}
+}
+
+func TestDecorrelatedJitterReconnect_MaxDuration(t *testing.T) {
+	policy := DecorrelatedJitterReconnect{
+		Base: time.Second,
+		Max:  math.MaxInt64,
+	}
+	previous := time.Duration(0)
+	for attempt := 1; attempt < 100; attempt++ {
+		delay, _ := policy.NextDelay(attempt, previous)
+		if delay < policy.Base {
+			t.Fatalf("Wrong delay for attempt %d: %s (previous %s)", attempt, delay, previous)
+		}
+		previous = delay
+	}
+	if delay, _ := policy.NextDelay(1, math.MaxInt64); delay < policy.Base {
+		t.Fatal("Wrong delay after the maximum one:", delay)
+	}
+}
+
+func TestConstantAndNeverReconnect(t *testing.T) {
+	if delay, ok := (ConstantReconnect{Delay: time.Second}).NextDelay(5, 0); !ok || delay != time.Second {
+		t.Fatal("Wrong constant delay:", delay)
+	}
+	if _, ok := (NeverReconnect{}).NextDelay(0, 0); ok {
+		t.Fatal("NeverReconnect allows reconnecting")
+	}
+}
+
+func TestMaxReconnectAttempts(t *testing.T) {
+	client, _ := New("ws://some-host-name.www", "123", Options{
+		ReconnectPolicy:      ConstantReconnect{},
+		MaxReconnectAttempts: 3,
+	})
+
+	client.reconnectCount = 2
+	if _, ok := client.nextReconnectInterval(); !ok {
+		t.Fatal("Gave up reconnecting too early")
+	}
+	client.reconnectCount = 3
+	if _, ok := client.nextReconnectInterval(); ok {
+		t.Fatal("MaxReconnectAttempts exceeded")
+	}
+}
+
+func TestLocal_GiveUp(t *testing.T) {
+	srv := rtmtest.NewServer()
+	srv.Close()
+
+	// Nobody listens on the endpoint anymore
+	client := getLocalRTM(srv, Options{
+		ReconnectPolicy:      ConstantReconnect{Delay: 10 * time.Millisecond},
+		MaxReconnectAttempts: 2,
+	})
+
+	awaiting := make(chan bool, 10)
+	client.OnAwaiting(func() {
+		awaiting <- true
+	})
+	gaveUp := make(chan bool, 1)
+	client.OnGiveUpOnce(func() {
+		gaveUp <- true
+	})
+	go client.Start()
+
+	select {
+	case <-gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not give up reconnecting")
+	}
+
+	if len(awaiting) != 3 {
+		t.Fatal("Wrong number of reconnect attempts:", len(awaiting)-1)
+	}
+	if client.fsm.CurrentState() != STATE_STOPPED {
+		t.Fatal("Client is not stopped after giving up")
+	}
+}
+
+func TestLocal_NeverReconnect(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		ReconnectPolicy: NeverReconnect{},
+	})
+	gaveUp := make(chan bool, 1)
+	client.OnGiveUpOnce(func() {
+		gaveUp <- true
+	})
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.CloseConnections()
+
+	select {
+	case <-gaveUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not give up reconnecting")
+	}
+}
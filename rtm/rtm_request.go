@@ -0,0 +1,122 @@
+package rtm
+
+import (
+	"context"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+)
+
+var (
+	ERROR_STREAMS_NOT_SUPPORTED = errors.New("Transport does not support multi-response requests")
+)
+
+// Sends the request with an arbitrary action, e.g. an action the SDK does not wrap yet, and waits for the response.
+// The body is encoded like the bodies of other requests, so pass a struct, a map or json.RawMessage.
+// The RTM client must be connected.
+//
+// Returns the response PDU. If RTM responds with the "/error" action, returns the response along with
+// RTMError with ERROR_CODE_APPLICATION code and pdu.ServerError reason.
+//
+// If the context is done before RTM responds, returns RTMError with ERROR_CODE_CONTEXT code and ctx.Err() reason.
+func (rtm *RTMClient) Request(ctx context.Context, action string, body interface{}) (pdu.RTMQuery, error) {
+	c, err := rtm.socketSend(ctx, action, body, ACK)
+	if err != nil {
+		return pdu.RTMQuery{}, err
+	}
+
+	response, err := awaitResponse(ctx, c)
+	if err != nil {
+		return pdu.RTMQuery{}, err
+	}
+	return response, responseError(response)
+}
+
+// Sends the request with an arbitrary action that RTM answers with several PDUs, e.g. "rtm/search".
+// The RTM client must be connected.
+//
+// Returns the channel that receives every response with the same id, e.g. "rtm/search/data" PDUs,
+// including the terminal "/ok" or "/error" response, and then is closed. The "/error" response
+// has Err set to RTMError with ERROR_CODE_APPLICATION code and pdu.ServerError reason.
+// If the request fails, the channel receives the error and is closed.
+//
+// Read the channel until it is closed or cancel the context: responses are not read from the connection
+// while the channel is full. Options.AckTimeout bounds the time between responses.
+//
+// Multi-response requests bypass the offline queue. If the transport set with Options.Transport does not
+// implement connection.StreamTransport, the channel receives RTMError with ERROR_STREAMS_NOT_SUPPORTED reason.
+func (rtm *RTMClient) RequestStream(ctx context.Context, action string, body interface{}) <-chan RequestResponse {
+	retCh := make(chan RequestResponse, 1)
+
+	c, err := rtm.sendStream(ctx, action, body)
+	if err != nil {
+		retCh <- RequestResponse{
+			Err: err,
+		}
+		close(retCh)
+		return retCh
+	}
+
+	go func() {
+		defer close(retCh)
+		// Drain the responses if the reader is gone, so the request is completed
+		defer func() {
+			for range c {
+			}
+		}()
+
+		for {
+			response, err := awaitResponse(ctx, c)
+			if err == nil && response.Action == "" {
+				// The channel is closed after the terminal response
+				return
+			}
+			if err == nil {
+				err = responseError(response)
+			}
+
+			select {
+			case retCh <- RequestResponse{Response: response, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return retCh
+}
+
+// Sends the multi-response request bypassing the offline queue
+func (rtm *RTMClient) sendStream(ctx context.Context, action string, body interface{}) (<-chan connection.Ack, error) {
+	if rtm.isShuttingDown() {
+		return nil, RTMError{
+			Code:   ERROR_CODE_APPLICATION,
+			Reason: ERROR_SHUTTING_DOWN,
+		}
+	}
+	if err := rtm.checkSend(ctx); err != nil {
+		return nil, err
+	}
+
+	conn, ok := rtm.conn.(connection.StreamTransport)
+	if !ok {
+		return nil, RTMError{
+			Code:   ERROR_CODE_APPLICATION,
+			Reason: ERROR_STREAMS_NOT_SUPPORTED,
+		}
+	}
+
+	ch, err := conn.SendStreamCtx(ctx, action, body)
+	return rtm.checkSent(ctx, ch, err, ACK)
+}
+
+// Returns RTMError with pdu.ServerError reason if RTM responds with the "/error" action
+func responseError(response pdu.RTMQuery) error {
+	if pdu.GetResponseCode(response) == pdu.CODE_ERROR_REQUEST {
+		return RTMError{
+			Code:   ERROR_CODE_APPLICATION,
+			Reason: pdu.GetResponseError(response),
+		}
+	}
+	return nil
+}
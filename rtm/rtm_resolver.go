@@ -0,0 +1,185 @@
+package rtm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_SRV_SERVICE        = "rtm"
+	DEFAULT_SRV_PROTO          = "tcp"
+	DEFAULT_SRV_LOOKUP_TIMEOUT = 5 * time.Second
+)
+
+var (
+	ERROR_NO_SRV_RECORDS = errors.New("No SRV records found")
+)
+
+// Looks up DNS records. *net.Resolver implements DNSResolver
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Discovers endpoints using DNS SRV records (RFC 2782), e.g.:
+//
+//   _rtm._tcp.example.com. 300 IN SRV 10 60 443 rtm-us.example.com.
+//   _rtm._tcp.example.com. 300 IN SRV 10 40 443 rtm-eu.example.com.
+//   _rtm._tcp.example.com. 300 IN SRV 20 0  443 rtm-backup.example.com.
+//   _rtm._tcp.example.com. 300 IN TXT "scheme=wss" "path=/"
+//
+// Endpoints are ordered by priority. Endpoints with the same priority are shuffled according to their weights,
+// so the load is spread between them. Optional TXT records with the same name define the scheme (ws or wss,
+// wss by default) and the path of the endpoints as key=value pairs.
+//
+// Use SRVResolver as Options.EndpointResolver: the records are looked up before every connection attempt.
+type SRVResolver struct {
+	// Domain name to look up, e.g. "example.com"
+	Name string
+
+	// Service and protocol of the SRV records. Default to DEFAULT_SRV_SERVICE and DEFAULT_SRV_PROTO
+	Service string
+	Proto   string
+
+	// DNS resolver to use. Defaults to net.DefaultResolver
+	Resolver DNSResolver
+
+	// Maximum time to look up the records. Defaults to DEFAULT_SRV_LOOKUP_TIMEOUT
+	Timeout time.Duration
+}
+
+func (r SRVResolver) ResolveEndpoints() ([]string, error) {
+	service, proto := r.Service, r.Proto
+	if len(service) == 0 {
+		service = DEFAULT_SRV_SERVICE
+	}
+	if len(proto) == 0 {
+		proto = DEFAULT_SRV_PROTO
+	}
+	var resolver DNSResolver = net.DefaultResolver
+	if r.Resolver != nil {
+		resolver = r.Resolver
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SRV_LOOKUP_TIMEOUT
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cname, records, err := resolver.LookupSRV(ctx, service, proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ERROR_NO_SRV_RECORDS
+	}
+	if len(cname) == 0 {
+		cname = "_" + service + "._" + proto + "." + r.Name
+	}
+
+	scheme, path := "wss", "/"
+	if txt, err := resolver.LookupTXT(ctx, cname); err == nil {
+		scheme, path = parseEndpointTXT(txt, scheme, path)
+	}
+
+	var endpoints []string
+	for _, record := range orderSRV(records) {
+		// "." target means the service is not available at this domain
+		host := strings.TrimSuffix(record.Target, ".")
+		if len(host) == 0 {
+			continue
+		}
+		endpoint := url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(host, strconv.Itoa(int(record.Port))),
+			Path:   path,
+		}
+		endpoints = append(endpoints, endpoint.String())
+	}
+	if len(endpoints) == 0 {
+		return nil, ERROR_NO_SRV_RECORDS
+	}
+	return endpoints, nil
+}
+
+// Parses "scheme=..." and "path=..." attributes. Unknown attributes are ignored
+func parseEndpointTXT(txt []string, scheme, path string) (string, string) {
+	for _, record := range txt {
+		for _, attribute := range strings.Fields(record) {
+			parts := strings.SplitN(attribute, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch strings.ToLower(parts[0]) {
+			case "scheme":
+				if parts[1] == "ws" || parts[1] == "wss" {
+					scheme = parts[1]
+				}
+			case "path":
+				path = parts[1]
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+			}
+		}
+	}
+	return scheme, path
+}
+
+// Orders the records by priority. Records with the same priority are ordered by the weighted random
+// selection described in RFC 2782: a record with a higher weight is more likely to come first
+func orderSRV(records []*net.SRV) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		shuffleByWeight(sorted[start:end])
+		start = end
+	}
+	return sorted
+}
+
+func shuffleByWeight(records []*net.SRV) {
+	sum := 0
+	for _, record := range records {
+		sum += int(record.Weight)
+	}
+	for i := range records {
+		if sum == 0 {
+			// Only zero weights are left: keep them in random order
+			rand.Shuffle(len(records)-i, func(a, b int) {
+				records[i+a], records[i+b] = records[i+b], records[i+a]
+			})
+			return
+		}
+
+		n := rand.Intn(sum) + 1
+		chosen := i
+		running := 0
+		for j := i; j < len(records); j++ {
+			running += int(records[j].Weight)
+			if running >= n && records[j].Weight > 0 {
+				chosen = j
+				break
+			}
+		}
+		records[i], records[chosen] = records[chosen], records[i]
+		sum -= int(records[i].Weight)
+	}
+}
@@ -0,0 +1,155 @@
+package rtm
+
+import (
+	"context"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// Local stub resolver. Answers with the configured records and counts lookups
+type stubResolver struct {
+	srv     []*net.SRV
+	txt     []string
+	err     error
+	lookups int32
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	atomic.AddInt32(&r.lookups, 1)
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return "_" + service + "._" + proto + "." + name + ".", r.srv, nil
+}
+
+func (r *stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if len(r.txt) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return r.txt, nil
+}
+
+func TestSRVResolver_Priority(t *testing.T) {
+	resolver := SRVResolver{
+		Name: "example.com",
+		Resolver: &stubResolver{
+			srv: []*net.SRV{
+				{Target: "backup.example.com.", Port: 8443, Priority: 20, Weight: 0},
+				{Target: "eu.example.com.", Port: 443, Priority: 10, Weight: 0},
+				{Target: ".", Port: 443, Priority: 30, Weight: 0},
+			},
+			txt: []string{"scheme=ws path=/rtm", "unknown=value"},
+		},
+	}
+
+	endpoints, err := resolver.ResolveEndpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ws://eu.example.com:443/rtm", "ws://backup.example.com:8443/rtm"}
+	if len(endpoints) != len(expected) {
+		t.Fatal("Wrong endpoints:", endpoints)
+	}
+	for i := range expected {
+		if endpoints[i] != expected[i] {
+			t.Fatal("Wrong endpoints:", endpoints)
+		}
+	}
+}
+
+func TestSRVResolver_Weight(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "light", Priority: 10, Weight: 1},
+		{Target: "heavy", Priority: 10, Weight: 9},
+		{Target: "zero", Priority: 10, Weight: 0},
+	}
+
+	first := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		ordered := orderSRV(records)
+		if len(ordered) != 3 {
+			t.Fatal("Records are lost:", ordered)
+		}
+		if ordered[2].Target != "zero" {
+			t.Fatal("Zero weight record is chosen before weighted ones")
+		}
+		first[ordered[0].Target]++
+	}
+
+	// Expected 900 vs 100
+	if first["heavy"] < 800 || first["light"] < 50 {
+		t.Fatal("Records are not ordered by weight:", first)
+	}
+	if records[0].Target != "light" {
+		t.Fatal("Original records are modified")
+	}
+}
+
+func TestSRVResolver_Errors(t *testing.T) {
+	resolver := SRVResolver{Name: "example.com", Resolver: &stubResolver{}}
+	if _, err := resolver.ResolveEndpoints(); err != ERROR_NO_SRV_RECORDS {
+		t.Fatal("Wrong error returned:", err)
+	}
+
+	dnsErr := errors.New("DNS failure")
+	resolver.Resolver = &stubResolver{err: dnsErr}
+	if _, err := resolver.ResolveEndpoints(); err != dnsErr {
+		t.Fatal("Wrong error returned:", err)
+	}
+}
+
+func TestLocal_SRVResolver(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	host, portStr, _ := net.SplitHostPort(u.Host)
+	port, _ := strconv.Atoi(portStr)
+	stub := &stubResolver{
+		srv: []*net.SRV{{Target: host, Port: uint16(port), Priority: 10, Weight: 10}},
+		txt: []string{"scheme=ws"},
+	}
+
+	client, _ := New("ws://127.0.0.1:1", "local-appkey", Options{
+		EndpointResolver: SRVResolver{Name: "example.com", Resolver: stub},
+	})
+	defer client.Stop()
+
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	if client.Endpoint() != "ws://"+u.Host+"/v2" {
+		t.Fatal("Client is not connected to the resolved endpoint:", client.Endpoint())
+	}
+	if atomic.LoadInt32(&stub.lookups) != 1 {
+		t.Fatal("Records are not looked up before connecting")
+	}
+}
+
+func TestSRVResolver_FailbackSamePriority(t *testing.T) {
+	set := newEndpointSet("wss://static/v2", Options{
+		EndpointResolver: SRVResolver{
+			Name: "example.com",
+			Resolver: &stubResolver{
+				srv: []*net.SRV{
+					{Target: "a.example.com.", Port: 443, Priority: 10, Weight: 50},
+					{Target: "b.example.com.", Port: 443, Priority: 10, Weight: 50},
+				},
+			},
+		},
+	})
+
+	// The records of the same priority are shuffled on every lookup: none of them is more preferred
+	set.next()
+	for i := 0; i < 100; i++ {
+		if set.preferredAvailable() {
+			t.Fatal("Healthy endpoint of the same priority is dropped for failback")
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package rtm
+
+import (
+	"context"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/logger"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ERROR_SHUTTING_DOWN = errors.New("Client is shutting down")
+)
+
+// Result of the graceful shutdown
+type ShutdownSummary struct {
+	// Requests that completed while the client was shutting down
+	Drained int
+
+	// Requests that did not complete before the context was done, including requests
+	// in the offline queue. They fail with ERROR_NOT_CONNECTED or connection.ERROR_CONNECTION_LOST
+	Abandoned int
+
+	// Subscriptions that RTM confirmed to unsubscribe. Check Options.UnsubscribeOnShutdown
+	Unsubscribed int
+
+	// Subscriptions that were not unsubscribed: RTM returned an error, the client was not connected
+	// or the context was done
+	UnsubscribeFailed int
+}
+
+// Counts requests that wait for the acknowledge
+type requestTracker struct {
+	mutex     sync.Mutex
+	pending   int
+	completed int
+
+	// Closed and replaced every time a request completes
+	changed chan struct{}
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{
+		changed: make(chan struct{}),
+	}
+}
+
+// Returns the go-channel that receives the same Acks as ch. The request is pending until ch is closed
+func (t *requestTracker) track(ch <-chan connection.Ack) <-chan connection.Ack {
+	t.mutex.Lock()
+	t.pending++
+	t.mutex.Unlock()
+
+	out := make(chan connection.Ack, 1)
+	go func() {
+		for ack := range ch {
+			out <- ack
+		}
+		close(out)
+
+		t.mutex.Lock()
+		t.pending--
+		t.completed++
+		close(t.changed)
+		t.changed = make(chan struct{})
+		t.mutex.Unlock()
+	}()
+	return out
+}
+
+func (t *requestTracker) state() (pending int, completed int, changed <-chan struct{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.pending, t.completed, t.changed
+}
+
+// Returns the number of queued requests and the go-channel that is closed when the queue changes
+func (q *offlineQueue) state() (int, <-chan struct{}) {
+	if q == nil {
+		return 0, nil
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items), q.changed
+}
+
+// Gracefully stops the client:
+//  - New requests and subscriptions fail with ERROR_SHUTTING_DOWN;
+//  - Waits until RTM acknowledges the requests in flight and the requests in the offline queue are sent;
+//  - Unsubscribes from all subscriptions if Options.UnsubscribeOnShutdown is set;
+//  - Stops the client, like Stop().
+//
+// If the context is done before all requests are acknowledged, the client is stopped anyway:
+// the remaining requests are abandoned and ctx.Err() is returned along with the summary.
+//
+// Do not call Shutdown from event callbacks: it waits for the client to enter STATE_STOPPED.
+// Call Start() to use the client again.
+func (rtm *RTMClient) Shutdown(ctx context.Context) (ShutdownSummary, error) {
+	var summary ShutdownSummary
+	atomic.StoreInt32(&rtm.shuttingDown, 1)
+	logger.Info("Client: Shutting down")
+
+	_, completedBefore, _ := rtm.requests.state()
+	err := rtm.drain(ctx)
+
+	pending, completed, _ := rtm.requests.state()
+	queued, _ := rtm.offlineQueue.state()
+	summary.Drained = completed - completedBefore
+	summary.Abandoned = pending + queued
+
+	if rtm.opts.UnsubscribeOnShutdown {
+		summary.Unsubscribed, summary.UnsubscribeFailed = rtm.unsubscribeAll(ctx)
+		if summary.UnsubscribeFailed > 0 && err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	rtm.stopAndWait()
+	logger.Info("Client: Shutdown complete. Drained:", summary.Drained, "abandoned:", summary.Abandoned)
+	return summary, err
+}
+
+// Waits until there are no requests in flight and the offline queue is empty
+func (rtm *RTMClient) drain(ctx context.Context) error {
+	for {
+		pending, _, requestsChanged := rtm.requests.state()
+		queued, queueChanged := rtm.offlineQueue.state()
+		if pending == 0 && queued == 0 {
+			return nil
+		}
+		// Queued requests are never sent by the stopped client
+		if pending == 0 && rtm.fsm.CurrentState() == STATE_STOPPED {
+			return nil
+		}
+
+		select {
+		case <-requestsChanged:
+		case <-queueChanged:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Sends rtm/unsubscribe for every subscription. Returns the number of confirmed and failed unsubscribes
+func (rtm *RTMClient) unsubscribeAll(ctx context.Context) (int, int) {
+	rtm.subscriptions.mutex.Lock()
+	ids := make([]string, 0, len(rtm.subscriptions.list))
+	for id := range rtm.subscriptions.list {
+		ids = append(ids, id)
+	}
+	rtm.subscriptions.mutex.Unlock()
+
+	if !rtm.IsConnected() {
+		return 0, len(ids)
+	}
+
+	var responses []<-chan UnsunscribeResponse
+	for _, id := range ids {
+		responses = append(responses, rtm.UnsubscribeCtx(ctx, id))
+	}
+
+	unsubscribed, failed := 0, 0
+	for _, ch := range responses {
+		if response := <-ch; response.Err != nil {
+			failed++
+		} else {
+			unsubscribed++
+		}
+	}
+	return unsubscribed, failed
+}
+
+func (rtm *RTMClient) stopAndWait() {
+	stopped := make(chan struct{})
+	var once sync.Once
+	id := rtm.On(EVENT_STOPPED, func(interface{}) {
+		once.Do(func() {
+			close(stopped)
+		})
+	})
+	defer rtm.Off(EVENT_STOPPED, id)
+
+	if rtm.fsm.CurrentState() == STATE_STOPPED {
+		return
+	}
+	rtm.Stop()
+	<-stopped
+}
+
+func (rtm *RTMClient) isShuttingDown() bool {
+	return atomic.LoadInt32(&rtm.shuttingDown) == 1
+}
+
+// Gracefully stops all clients of the pool. Check RTMClient.Shutdown
+func (p *Pool) Shutdown(ctx context.Context) (ShutdownSummary, error) {
+	type result struct {
+		summary ShutdownSummary
+		err     error
+	}
+	results := make(chan result, len(p.clients))
+	for _, client := range p.clients {
+		go func(client *RTMClient) {
+			summary, err := client.Shutdown(ctx)
+			results <- result{summary, err}
+		}(client)
+	}
+
+	var total ShutdownSummary
+	var err error
+	for range p.clients {
+		r := <-results
+		total.Drained += r.summary.Drained
+		total.Abandoned += r.summary.Abandoned
+		total.Unsubscribed += r.summary.Unsubscribed
+		total.UnsubscribeFailed += r.summary.UnsubscribeFailed
+		if err == nil {
+			err = r.err
+		}
+	}
+	return total, err
+}
@@ -0,0 +1,169 @@
+package rtm
+
+import (
+	"context"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+	"testing"
+	"time"
+)
+
+func TestLocal_Shutdown_Drain(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			conn.Reply(query, "ok", pdu.PublishBodyResponse{Position: "1"})
+		}()
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	var responses []<-chan PublishResponse
+	for i := 0; i < 5; i++ {
+		responses = append(responses, client.PublishAck(getChannel(), i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	summary, err := client.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Drained != 5 || summary.Abandoned != 0 {
+		t.Fatal("Wrong summary:", summary)
+	}
+	for _, ch := range responses {
+		if response := <-ch; response.Err != nil {
+			t.Fatal("Request is not drained:", response.Err)
+		}
+	}
+	if client.IsConnected() {
+		t.Fatal("Client is not stopped")
+	}
+
+	response := <-client.PublishAck(getChannel(), "late")
+	if err, ok := response.Err.(RTMError); !ok || err.Reason != ERROR_SHUTTING_DOWN {
+		t.Fatal("New request is accepted after Shutdown:", response.Err)
+	}
+	err = client.Subscribe(getChannel(), subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{})
+	if err, ok := err.(RTMError); !ok || err.Reason != ERROR_SHUTTING_DOWN {
+		t.Fatal("New subscription is accepted after Shutdown:", err)
+	}
+
+	// The client can be started again
+	srv.HandleFunc("rtm/publish", nil)
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	if response := <-client.PublishAck(getChannel(), "restarted"); response.Err != nil {
+		t.Fatal(response.Err)
+	}
+}
+
+func TestLocal_Shutdown_Abandon(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+	srv.HandleFunc("rtm/publish", func(conn *rtmtest.Conn, query pdu.RTMQuery) {
+		// Never reply
+	})
+
+	client := getLocalRTM(srv, Options{})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	first := client.PublishAck(getChannel(), 1)
+	second := client.PublishAck(getChannel(), 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	summary, err := client.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatal("Wrong error returned:", err)
+	}
+	if summary.Drained != 0 || summary.Abandoned != 2 {
+		t.Fatal("Wrong summary:", summary)
+	}
+	for _, ch := range []<-chan PublishResponse{first, second} {
+		if response := <-ch; response.Err == nil {
+			t.Fatal("Abandoned request succeeded")
+		}
+	}
+}
+
+func TestLocal_Shutdown_Unsubscribe(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	client := getLocalRTM(srv, Options{
+		UnsubscribeOnShutdown: true,
+	})
+	defer client.Stop()
+
+	subscribed := make(chan bool, 2)
+	unsubscribed := make(chan bool, 2)
+	channels := []string{getChannel(), getChannel()}
+	for _, channel := range channels {
+		client.Subscribe(channel, subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+			OnSubscribed: func(pdu.SubscribeOk) {
+				subscribed <- true
+			},
+			OnUnsubscribed: func(pdu.UnsubscribeBodyResponse) {
+				unsubscribed <- true
+			},
+		})
+	}
+
+	go client.Start()
+	for range channels {
+		select {
+		case <-subscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Unable to subscribe")
+		}
+	}
+
+	summary, err := client.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Unsubscribed != 2 || summary.UnsubscribeFailed != 0 {
+		t.Fatal("Wrong summary:", summary)
+	}
+	for _, channel := range channels {
+		select {
+		case <-unsubscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnUnsubscribed is not called")
+		}
+		if _, err := client.GetSubscription(channel); err != ERROR_SUBSCRIPTION_NOT_FOUND {
+			t.Fatal("Subscription is not removed")
+		}
+	}
+}
+
+func TestShutdown_NotStarted(t *testing.T) {
+	client, _ := New("ws://127.0.0.1:1", "appkey", Options{
+		OfflineQueueSize: 10,
+	})
+	client.PublishAck("channel", 1)
+
+	summary, err := client.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Abandoned != 1 {
+		t.Fatal("Wrong summary:", summary)
+	}
+}
@@ -0,0 +1,235 @@
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/connection"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/rtmtest"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// In-memory transport. Requests are passed to the handler, PDUs pushed to incoming are returned by Read
+type memoryTransport struct {
+	handler  func(t *memoryTransport, query pdu.RTMQuery) json.RawMessage
+	incoming chan pdu.RTMQuery
+
+	mutex     sync.Mutex
+	lastID    int
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemoryTransport(handler func(t *memoryTransport, query pdu.RTMQuery) json.RawMessage) *memoryTransport {
+	return &memoryTransport{
+		handler:  handler,
+		incoming: make(chan pdu.RTMQuery, 100),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (t *memoryTransport) Send(action string, body json.RawMessage) error {
+	t.handler(t, pdu.RTMQuery{Action: action, Body: body})
+	return nil
+}
+
+func (t *memoryTransport) SendAckCtx(ctx context.Context, action string, body json.RawMessage) (<-chan connection.Ack, error) {
+	t.mutex.Lock()
+	t.lastID++
+	id := strconv.Itoa(t.lastID)
+	t.mutex.Unlock()
+
+	ch := make(chan connection.Ack, 1)
+	response := t.handler(t, pdu.RTMQuery{Action: action, Body: body, Id: id})
+	ch <- connection.Ack{
+		Response: pdu.RTMQuery{Action: action + "/ok", Body: response, Id: id},
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (t *memoryTransport) Read() (pdu.RTMQuery, error) {
+	select {
+	case query := <-t.incoming:
+		return query, nil
+	case <-t.closed:
+		return pdu.RTMQuery{}, connection.ERROR_CONNECTION_LOST
+	}
+}
+
+func (t *memoryTransport) Close() {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+}
+
+// Wraps a transport and fails all requests while fail is set
+type faultyTransport struct {
+	connection.Transport
+	fail *int32
+}
+
+var errInjected = errors.New("Injected fault")
+
+func (t faultyTransport) Send(action string, body json.RawMessage) error {
+	if atomic.LoadInt32(t.fail) != 0 {
+		t.Close()
+		return errInjected
+	}
+	return t.Transport.Send(action, body)
+}
+
+func (t faultyTransport) SendAckCtx(ctx context.Context, action string, body json.RawMessage) (<-chan connection.Ack, error) {
+	if atomic.LoadInt32(t.fail) != 0 {
+		t.Close()
+		return nil, errInjected
+	}
+	return t.Transport.SendAckCtx(ctx, action, body)
+}
+
+// Wraps a transport and never completes requests with acknowledge, ignoring the context
+type silentTransport struct {
+	connection.Transport
+}
+
+func (t silentTransport) SendAckCtx(ctx context.Context, action string, body json.RawMessage) (<-chan connection.Ack, error) {
+	return make(chan connection.Ack), nil
+}
+
+func TestTransport_InMemory(t *testing.T) {
+	var endpoint string
+	transport := newMemoryTransport(func(t *memoryTransport, query pdu.RTMQuery) json.RawMessage {
+		switch query.Action {
+		case "rtm/publish":
+			var body pdu.PublishBody
+			json.Unmarshal(query.Body, &body)
+			message, _ := json.Marshal(body.Message)
+			data, _ := json.Marshal(pdu.SubscriptionData{
+				Position:       "1:1",
+				Messages:       []json.RawMessage{message},
+				SubscriptionId: body.Channel,
+			})
+			t.incoming <- pdu.RTMQuery{Action: "rtm/subscription/data", Body: data}
+			return json.RawMessage(`{"position":"1:1"}`)
+		case "rtm/subscribe":
+			var body pdu.SubscribeBody
+			json.Unmarshal(query.Body, &body)
+			return json.RawMessage(`{"position":"1:0","subscription_id":"` + body.Channel + `"}`)
+		}
+		return json.RawMessage(`{}`)
+	})
+
+	client, _ := New("ws://in-memory", "appkey", Options{
+		Transport: func(e string) (connection.Transport, error) {
+			endpoint = e
+			return transport, nil
+		},
+	})
+	defer client.Stop()
+
+	messages := make(chan string, 1)
+	client.Subscribe("channel", subscription.SIMPLE, pdu.SubscribeBodyOpts{}, subscription.Listener{
+		OnData: func(data pdu.SubscriptionData) {
+			messages <- string(data.Messages[0])
+		},
+	})
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(endpoint, "?appkey=appkey") {
+		t.Fatal("Wrong endpoint passed to the transport factory:", endpoint)
+	}
+
+	response := <-client.PublishAck("channel", "hello")
+	if response.Err != nil || response.Response.Position != "1:1" {
+		t.Fatal("Wrong publish response:", response)
+	}
+	select {
+	case message := <-messages:
+		if message != `"hello"` {
+			t.Fatal("Wrong message:", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unable to get the message from the transport")
+	}
+
+	stream := <-client.RequestStream(context.Background(), "rtm/search", map[string]string{})
+	if rtmErr, ok := stream.Err.(RTMError); !ok || rtmErr.Reason != ERROR_STREAMS_NOT_SUPPORTED {
+		t.Fatal("Wrong error for the transport without streams:", stream.Err)
+	}
+}
+
+func TestTransport_FaultInjection(t *testing.T) {
+	srv := rtmtest.NewServer()
+	defer srv.Close()
+
+	var fail int32
+	client := getLocalRTM(srv, Options{
+		ReconnectPolicy: ConstantReconnect{Delay: 10 * time.Millisecond},
+		Transport: func(endpoint string) (connection.Transport, error) {
+			conn, err := connection.New(endpoint, connection.Options{})
+			if err != nil {
+				return nil, err
+			}
+			return faultyTransport{conn, &fail}, nil
+		},
+	})
+	defer client.Stop()
+
+	connected := make(chan bool, 2)
+	client.OnConnected(func() {
+		connected <- true
+	})
+	go client.Start()
+	<-connected
+
+	atomic.StoreInt32(&fail, 1)
+	if write := <-client.Write(getChannel(), 1); write.Err != errInjected {
+		t.Fatal("Injected fault is not returned:", write.Err)
+	}
+	atomic.StoreInt32(&fail, 0)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect after the fault")
+	}
+	if write := <-client.Write(getChannel(), 1); write.Err != nil {
+		t.Fatal(write.Err)
+	}
+}
+
+func TestTransport_IgnoresContext(t *testing.T) {
+	transport := newMemoryTransport(func(t *memoryTransport, query pdu.RTMQuery) json.RawMessage {
+		return json.RawMessage(`{}`)
+	})
+	client, _ := New("ws://in-memory", "appkey", Options{
+		Transport: func(string) (connection.Transport, error) {
+			return silentTransport{transport}, nil
+		},
+	})
+	defer client.Stop()
+	go client.Start()
+	if err := waitForConnected(client); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	select {
+	case response := <-client.PublishAckCtx(ctx, "channel", "message"):
+		if rtmErr, ok := response.Err.(RTMError); !ok || rtmErr.Code != ERROR_CODE_CONTEXT || rtmErr.Reason != context.DeadlineExceeded {
+			t.Fatal("Wrong error returned:", response.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request did not complete after the context is done")
+	}
+}
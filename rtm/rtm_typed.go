@@ -0,0 +1,76 @@
+package rtm
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/satori-com/satori-rtm-sdk-go/logger"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/subscription"
+)
+
+// Listener of the subscription created with SubscribeTyped
+type TypedListener[T any] struct {
+	// Called with the messages of the subscription data decoded into T.
+	// Messages that cannot be decoded are passed to OnDecodeError instead
+	OnData func(messages []T)
+
+	// Called for every message that cannot be decoded into T. If not set, the message is logged and skipped
+	OnDecodeError func(message json.RawMessage, err error)
+
+	// Other subscription callbacks. Listener.OnData is replaced with OnData
+	Listener subscription.Listener
+}
+
+// Creates a subscription like RTMClient.Subscribe, but decodes the incoming messages from JSON into T:
+//
+//   rtm.SubscribeTyped(client, "animals", subscription.SIMPLE, pdu.SubscribeBodyOpts{}, rtm.TypedListener[Animal]{
+//     OnData: func(animals []Animal) {
+//       // Process animals
+//     },
+//     OnDecodeError: func(message json.RawMessage, err error) {
+//       fmt.Println("Not an animal:", string(message), err)
+//     },
+//   })
+func SubscribeTyped[T any](rtm *RTMClient, subscriptionId string, mode subscription.Mode, opts pdu.SubscribeBodyOpts, listener TypedListener[T]) error {
+	return SubscribeTypedCtx(context.Background(), rtm, subscriptionId, mode, opts, listener)
+}
+
+// Creates a subscription like RTMClient.SubscribeCtx, but decodes the incoming messages from JSON into T.
+// Check SubscribeTyped
+func SubscribeTypedCtx[T any](ctx context.Context, rtm *RTMClient, subscriptionId string, mode subscription.Mode, opts pdu.SubscribeBodyOpts, listener TypedListener[T]) error {
+	rawListener := listener.Listener
+	rawListener.OnData = func(data pdu.SubscriptionData) {
+		messages := decodeMessages[T](data.Messages, listener.OnDecodeError)
+		if len(messages) > 0 && listener.OnData != nil {
+			listener.OnData(messages)
+		}
+	}
+	return rtm.SubscribeCtx(ctx, subscriptionId, mode, opts, rawListener)
+}
+
+// Publishes the message of type T to the channel with Acknowledge. Check RTMClient.PublishAck
+func PublishTyped[T any](rtm *RTMClient, channel string, message T) <-chan PublishResponse {
+	return PublishTypedCtx(context.Background(), rtm, channel, message)
+}
+
+// Publishes the message of type T to the channel with Acknowledge. Check RTMClient.PublishAckCtx
+func PublishTypedCtx[T any](ctx context.Context, rtm *RTMClient, channel string, message T) <-chan PublishResponse {
+	return rtm.PublishAckCtx(ctx, channel, message)
+}
+
+func decodeMessages[T any](rawMessages []json.RawMessage, onDecodeError func(json.RawMessage, error)) []T {
+	messages := make([]T, 0, len(rawMessages))
+	for _, rawMessage := range rawMessages {
+		var message T
+		if err := json.Unmarshal(rawMessage, &message); err != nil {
+			if onDecodeError != nil {
+				onDecodeError(rawMessage, err)
+			} else {
+				logger.Warn("Unable to decode the message:", string(rawMessage), err)
+			}
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
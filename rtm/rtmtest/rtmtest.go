@@ -0,0 +1,948 @@
+// RTM test server.
+//
+// Provides an in-process WebSocket server that speaks the RTM v2 protocol, so the RTM client
+// can be tested end to end without a network connection or credentials.
+//
+//   srv := rtmtest.NewServer()
+//   defer srv.Close()
+//
+//   client, err := rtm.New(srv.URL, "<any-appkey>", rtm.Options{})
+//
+// The server supports the following actions:
+//
+//   auth/handshake, auth/authenticate,
+//   rtm/publish, rtm/subscribe, rtm/unsubscribe, rtm/read, rtm/write, rtm/delete, rtm/search
+//
+// Messages published to a channel are delivered to all subscribers as rtm/subscription/data PDUs.
+// Use SubscriptionInfo and SubscriptionError to simulate rtm/subscription/info and rtm/subscription/error
+// PDUs, e.g. "out_of_sync" or "expired_position" errors.
+// rtm/publish supports the ttl and ttl_message fields, rtm/subscribe supports "only": "value"
+// and rtm/delete supports the purge flag. rtm/search finds the channels that have a value, SEARCH_BATCH_SIZE
+// channels per rtm/search/data response.
+// Use HandleFunc to override the behavior for any action, e.g. to inject errors or to never reply.
+//
+// The server negotiates permessage-deflate compression if the client asks for it, and the "cbor"
+// subprotocol to talk to clients that use pdu.CBORCodec.
+package rtmtest
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_HISTORY_LENGTH = 1000
+
+	// Number of channels in every rtm/search/data response
+	SEARCH_BATCH_SIZE = 100
+)
+
+var (
+	filterChannelRe = regexp.MustCompile("(?i)\\bFROM\\s+`?([^`\\s]+)`?")
+)
+
+// Handles an incoming PDU. Use Conn.Reply or Conn.Send to respond.
+type HandlerFunc func(conn *Conn, query pdu.RTMQuery)
+
+// In-process RTM server
+type Server struct {
+	// Base URL of the server in form ws://127.0.0.1:port (wss:// for TLS servers)
+	// Can be passed to rtm.New as an endpoint
+	URL string
+
+	// TLS configuration of the server. Can be set before StartTLS is called
+	TLS *tls.Config
+
+	httpServer *httptest.Server
+	upgrader   websocket.Upgrader
+	epoch      int64
+
+	mutex       sync.Mutex
+	lastSeq     int64
+	ignorePings bool
+	roles       map[string]string
+	restricted  map[string]bool
+	handlers    map[string]HandlerFunc
+	channels    map[string]*channelType
+	conns       map[*Conn]bool
+}
+
+// Server-side client connection
+type Conn struct {
+	server *Server
+	wsConn *websocket.Conn
+	header http.Header
+	codec  pdu.Codec
+
+	mutex         sync.Mutex
+	subscriptions map[string]string
+	nonce         string
+	role          string
+	authenticated bool
+	outgoing      [][]byte
+
+	// Gorilla websocket package is not thread-safe. So we need to handle it by ourselves
+	wSockMutex sync.Mutex
+}
+
+type channelType struct {
+	history []storedMessage
+	value   *storedMessage
+
+	// Publishes the TTL message unless another message is published first
+	ttl *time.Timer
+}
+
+type storedMessage struct {
+	seq      int64
+	position string
+	message  json.RawMessage
+}
+
+// Starts a new server listening on the loopback interface
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// Starts a new TLS server listening on the loopback interface. The server uses self-signed certificate.
+// Use Certificate to get it
+func NewTLSServer() *Server {
+	s := NewUnstartedServer()
+	s.StartTLS()
+	return s
+}
+
+// Creates a new server, but does not start it. Call Start or StartTLS after changing the server configuration
+func NewUnstartedServer() *Server {
+	s := &Server{
+		epoch:      time.Now().Unix(),
+		roles:      make(map[string]string),
+		restricted: make(map[string]bool),
+		handlers:   make(map[string]HandlerFunc),
+		channels:   make(map[string]*channelType),
+		conns:      make(map[*Conn]bool),
+		upgrader: websocket.Upgrader{
+			EnableCompression: true,
+			Subprotocols:      []string{pdu.CBORCodec{}.Subprotocol()},
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	}
+	s.httpServer = httptest.NewUnstartedServer(http.HandlerFunc(s.serveWS))
+
+	return s
+}
+
+// Starts a server created by NewUnstartedServer
+func (s *Server) Start() {
+	s.httpServer.Start()
+	s.URL = "ws" + strings.TrimPrefix(s.httpServer.URL, "http")
+}
+
+// Starts TLS on a server created by NewUnstartedServer
+func (s *Server) StartTLS() {
+	s.httpServer.TLS = s.TLS
+	s.httpServer.StartTLS()
+	s.URL = "wss" + strings.TrimPrefix(s.httpServer.URL, "https")
+}
+
+// Gets the certificate used by the TLS server. Returns nil if the server does not use TLS
+func (s *Server) Certificate() *x509.Certificate {
+	return s.httpServer.Certificate()
+}
+
+// Drops all client connections and shuts down the server
+func (s *Server) Close() {
+	s.CloseConnections()
+	s.httpServer.Close()
+}
+
+// Drops all client connections. The server keeps listening, so clients are able to reconnect
+func (s *Server) CloseConnections() {
+	for _, conn := range s.Connections() {
+		conn.Close()
+	}
+}
+
+// Gets all active client connections
+func (s *Server) Connections() []*Conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	conns := make([]*Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Registers a role and its secret key for the role_secret authentication
+func (s *Server) AddRole(role, secret string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.roles[role] = secret
+}
+
+// Restricts access to the channel. Only authenticated connections can use the channel.
+// Other connections get "authorization_denied" error
+func (s *Server) RestrictChannel(channel string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.restricted[channel] = true
+}
+
+// Overrides the behavior for the action. Pass nil handler to restore the default behavior
+func (s *Server) HandleFunc(action string, handler HandlerFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if handler == nil {
+		delete(s.handlers, action)
+	} else {
+		s.handlers[action] = handler
+	}
+}
+
+// Stops answering WebSocket pings, so the connection looks dead to the clients
+// that use keepalive. The server still processes PDUs
+func (s *Server) IgnorePings(ignore bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ignorePings = ignore
+}
+
+// Publishes a message to the channel as if it was published by some other client.
+// Returns the position of the message
+func (s *Server) Publish(channel string, message json.RawMessage) string {
+	return s.publish(channel, message, false)
+}
+
+// Sends rtm/subscription/info to all subscribers of the channel, e.g. "fast_forward" info
+// when RTM skips messages the subscriber cannot keep up with
+func (s *Server) SubscriptionInfo(channel, info, reason string) {
+	s.sendToSubscribers(channel, false, func(subscriptionId, position string) pdu.RTMQuery {
+		body, _ := json.Marshal(pdu.SubscriptionInfo{
+			Info:           info,
+			Reason:         reason,
+			SubscriptionId: subscriptionId,
+			Position:       position,
+		})
+		return pdu.RTMQuery{Action: "rtm/subscription/info", Body: body}
+	})
+}
+
+// Sends rtm/subscription/error to all subscribers of the channel and drops their subscriptions, like RTM does
+// on "out_of_sync" or "expired_position" errors
+func (s *Server) SubscriptionError(channel, code, reason string) {
+	s.sendToSubscribers(channel, true, func(subscriptionId, position string) pdu.RTMQuery {
+		body, _ := json.Marshal(pdu.SubscriptionError{
+			Error:          code,
+			Reason:         reason,
+			Position:       position,
+			SubscriptionId: subscriptionId,
+		})
+		return pdu.RTMQuery{Action: "rtm/subscription/error", Body: body}
+	})
+}
+
+// Sends the PDU built for every subscription to the channel. Drops the subscriptions if unsubscribe is set
+func (s *Server) sendToSubscribers(channel string, unsubscribe bool, build func(subscriptionId, position string) pdu.RTMQuery) {
+	position := func() string {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.currentPosition()
+	}()
+
+	for _, conn := range s.Connections() {
+		for _, subscriptionId := range conn.subscriptionsFor(channel) {
+			if unsubscribe {
+				conn.mutex.Lock()
+				delete(conn.subscriptions, subscriptionId)
+				conn.mutex.Unlock()
+			}
+			conn.Send(build(subscriptionId, position))
+		}
+	}
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	wsConn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn := &Conn{
+		server:        s,
+		wsConn:        wsConn,
+		header:        r.Header,
+		codec:         pdu.JSONCodec{},
+		subscriptions: make(map[string]string),
+	}
+	if wsConn.Subprotocol() == (pdu.CBORCodec{}).Subprotocol() {
+		conn.codec = pdu.CBORCodec{}
+	}
+	wsConn.SetPingHandler(func(data string) error {
+		s.mutex.Lock()
+		ignore := s.ignorePings
+		s.mutex.Unlock()
+		if ignore {
+			return nil
+		}
+		return wsConn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	s.mutex.Lock()
+	s.conns[conn] = true
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := wsConn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var query pdu.RTMQuery
+		if err := conn.codec.Unmarshal(data, &query); err != nil {
+			body, _ := json.Marshal(pdu.Error{
+				Error:  "invalid_format",
+				Reason: "Unable to parse PDU",
+			})
+			conn.Send(pdu.RTMQuery{
+				Action: "/error",
+				Body:   body,
+			})
+			continue
+		}
+
+		s.mutex.Lock()
+		handler, ok := s.handlers[query.Action]
+		s.mutex.Unlock()
+		if !ok {
+			handler = s.defaultHandler(query.Action)
+		}
+		handler(conn, query)
+	}
+}
+
+func (s *Server) defaultHandler(action string) HandlerFunc {
+	switch action {
+	case "auth/handshake":
+		return s.handleHandshake
+	case "auth/authenticate":
+		return s.handleAuthenticate
+	case "rtm/publish":
+		return s.handlePublish
+	case "rtm/write":
+		return s.handleWrite
+	case "rtm/read":
+		return s.handleRead
+	case "rtm/delete":
+		return s.handleDelete
+	case "rtm/subscribe":
+		return s.handleSubscribe
+	case "rtm/unsubscribe":
+		return s.handleUnsubscribe
+	case "rtm/search":
+		return s.handleSearch
+	}
+	return handleUnknown
+}
+
+func handleUnknown(conn *Conn, query pdu.RTMQuery) {
+	conn.ReplyError(query, "invalid_service", "Unknown action: "+query.Action)
+}
+
+func (s *Server) handleHandshake(conn *Conn, query pdu.RTMQuery) {
+	var body struct {
+		Method string `json:"method"`
+		Data   struct {
+			Role string `json:"role"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(query.Body, &body); err != nil || body.Method != "role_secret" {
+		conn.ReplyError(query, "invalid_format", "Unsupported auth method")
+		return
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	conn.mutex.Lock()
+	conn.role = body.Data.Role
+	conn.nonce = base64.StdEncoding.EncodeToString(nonce)
+	conn.authenticated = false
+	reply := map[string]interface{}{
+		"data": map[string]string{
+			"nonce": conn.nonce,
+		},
+	}
+	conn.mutex.Unlock()
+
+	conn.Reply(query, "ok", reply)
+}
+
+func (s *Server) handleAuthenticate(conn *Conn, query pdu.RTMQuery) {
+	var body struct {
+		Method      string `json:"method"`
+		Credentials struct {
+			Hash string `json:"hash"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal(query.Body, &body); err != nil {
+		conn.ReplyError(query, "invalid_format", "Unable to parse credentials")
+		return
+	}
+
+	conn.mutex.Lock()
+	role := conn.role
+	conn.mutex.Unlock()
+
+	s.mutex.Lock()
+	secret, ok := s.roles[role]
+	s.mutex.Unlock()
+
+	conn.mutex.Lock()
+	success := ok && len(conn.nonce) != 0 && hmacMD5(conn.nonce, secret) == body.Credentials.Hash
+	conn.authenticated = success
+	conn.nonce = ""
+	conn.mutex.Unlock()
+
+	if !success {
+		conn.ReplyError(query, "authentication_failed", "Unauthenticated")
+		return
+	}
+	conn.Reply(query, "ok", struct{}{})
+}
+
+func (s *Server) handlePublish(conn *Conn, query pdu.RTMQuery) {
+	var body struct {
+		Channel    string          `json:"channel"`
+		Message    json.RawMessage `json:"message"`
+		Ttl        int             `json:"ttl"`
+		TtlMessage json.RawMessage `json:"ttl_message"`
+	}
+	if !s.parseChannelBody(conn, query, &body, &body.Channel) {
+		return
+	}
+	if len(body.Message) == 0 {
+		conn.ReplyError(query, "invalid_format", "Message is missing")
+		return
+	}
+	if body.Ttl < 0 || (body.Ttl == 0 && len(body.TtlMessage) != 0) {
+		conn.ReplyError(query, "invalid_format", "TTL must be a positive number of seconds")
+		return
+	}
+
+	position := s.publish(body.Channel, body.Message, false)
+	if body.Ttl > 0 {
+		ttlMessage := body.TtlMessage
+		if len(ttlMessage) == 0 {
+			ttlMessage = json.RawMessage("null")
+		}
+		s.expireAfter(body.Channel, time.Duration(body.Ttl)*time.Second, ttlMessage)
+	}
+	conn.Reply(query, "ok", pdu.PublishBodyResponse{
+		Position: position,
+	})
+}
+
+func (s *Server) handleWrite(conn *Conn, query pdu.RTMQuery) {
+	var body struct {
+		Channel string          `json:"channel"`
+		Message json.RawMessage `json:"message"`
+	}
+	if !s.parseChannelBody(conn, query, &body, &body.Channel) {
+		return
+	}
+	if len(body.Message) == 0 {
+		conn.ReplyError(query, "invalid_format", "Message is missing")
+		return
+	}
+
+	position := s.publish(body.Channel, body.Message, false)
+	conn.Reply(query, "ok", pdu.WriteBodyResponse{
+		Position: position,
+	})
+}
+
+func (s *Server) handleRead(conn *Conn, query pdu.RTMQuery) {
+	var body pdu.ReadBody
+	if !s.parseChannelBody(conn, query, &body, &body.Channel) {
+		return
+	}
+
+	s.mutex.Lock()
+	var found *storedMessage
+	if ch, ok := s.channels[body.Channel]; ok {
+		if len(body.Position) == 0 {
+			found = ch.value
+		} else {
+			for i := range ch.history {
+				if ch.history[i].position == body.Position {
+					found = &ch.history[i]
+					break
+				}
+			}
+		}
+	}
+	position := s.currentPosition()
+	s.mutex.Unlock()
+
+	response := pdu.ReadBodyResponse{
+		Message:  json.RawMessage("null"),
+		Position: position,
+	}
+	if found != nil {
+		response.Message = found.message
+		response.Position = found.position
+	}
+	conn.Reply(query, "ok", response)
+}
+
+func (s *Server) handleDelete(conn *Conn, query pdu.RTMQuery) {
+	var body pdu.DeleteBody
+	if !s.parseChannelBody(conn, query, &body, &body.Channel) {
+		return
+	}
+
+	position := s.publish(body.Channel, json.RawMessage("null"), true)
+	if body.Purge {
+		s.mutex.Lock()
+		s.channels[body.Channel].history = nil
+		s.mutex.Unlock()
+	}
+	conn.Reply(query, "ok", pdu.DeleteBodyResponse{
+		Position: position,
+	})
+}
+
+func (s *Server) handleSearch(conn *Conn, query pdu.RTMQuery) {
+	var body pdu.SearchBody
+	if err := json.Unmarshal(query.Body, &body); err != nil {
+		conn.ReplyError(query, "invalid_format", err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	channels := make([]string, 0)
+	for name, ch := range s.channels {
+		if ch.value != nil && strings.HasPrefix(name, body.Prefix) {
+			channels = append(channels, name)
+		}
+	}
+	s.mutex.Unlock()
+	sort.Strings(channels)
+
+	for len(channels) > SEARCH_BATCH_SIZE {
+		rawBody, _ := json.Marshal(pdu.SearchBodyResponse{
+			Channels: channels[:SEARCH_BATCH_SIZE],
+		})
+		conn.Send(pdu.RTMQuery{
+			Action: "rtm/search/data",
+			Id:     query.Id,
+			Body:   rawBody,
+		})
+		channels = channels[SEARCH_BATCH_SIZE:]
+	}
+	conn.Reply(query, "ok", pdu.SearchBodyResponse{
+		Channels: channels,
+	})
+}
+
+func (s *Server) handleSubscribe(conn *Conn, query pdu.RTMQuery) {
+	var body pdu.SubscribeBody
+	if err := json.Unmarshal(query.Body, &body); err != nil {
+		conn.ReplyError(query, "invalid_format", err.Error())
+		return
+	}
+
+	subscriptionId := body.SubscriptionId
+	channel := body.Channel
+	if len(body.Filter) != 0 {
+		match := filterChannelRe.FindStringSubmatch(body.Filter)
+		if match == nil {
+			conn.replySubscribeError(query, subscriptionId, "invalid_format", "Unable to parse filter")
+			return
+		}
+		channel = match[1]
+	}
+	if len(subscriptionId) == 0 {
+		subscriptionId = channel
+	}
+	if len(subscriptionId) == 0 || len(channel) == 0 {
+		conn.replySubscribeError(query, subscriptionId, "invalid_format", "Channel is missing")
+		return
+	}
+	if !conn.isAllowed(channel) {
+		conn.replySubscribeError(query, subscriptionId, "authorization_denied", "Unauthorized")
+		return
+	}
+
+	if len(body.Only) != 0 && body.Only != pdu.ONLY_VALUE {
+		conn.replySubscribeError(query, subscriptionId, "invalid_format", "Unknown only modifier: "+body.Only)
+		return
+	}
+
+	var fromSeq int64 = -1
+	if len(body.Position) != 0 {
+		seq, err := s.parsePosition(body.Position)
+		if err != nil {
+			conn.replySubscribeError(query, subscriptionId, "invalid_format", err.Error())
+			return
+		}
+		fromSeq = seq
+	}
+
+	conn.mutex.Lock()
+	_, exists := conn.subscriptions[subscriptionId]
+	if exists && !body.Force {
+		conn.mutex.Unlock()
+		conn.replySubscribeError(query, subscriptionId, "already_subscribed", "Subscription already exists")
+		return
+	}
+	conn.mutex.Unlock()
+
+	// Collect the messages to replay and register the subscription atomically,
+	// so no published message is lost or delivered twice
+	s.mutex.Lock()
+	var replay []storedMessage
+	if ch, ok := s.channels[channel]; ok && body.Only == pdu.ONLY_VALUE {
+		// Only the current value of the channel, unless the subscription has already received it
+		if ch.value != nil && ch.value.seq > fromSeq {
+			replay = append(replay, *ch.value)
+		}
+	} else if ok {
+		for _, m := range ch.history {
+			if fromSeq >= 0 && m.seq > fromSeq {
+				replay = append(replay, m)
+			}
+		}
+		if fromSeq < 0 && body.History.Count > 0 {
+			start := len(ch.history) - body.History.Count
+			if start < 0 {
+				start = 0
+			}
+			replay = append(replay, ch.history[start:]...)
+		}
+	}
+	position := s.currentPosition()
+
+	conn.mutex.Lock()
+	conn.subscriptions[subscriptionId] = channel
+	conn.mutex.Unlock()
+
+	// Queue the PDUs under the server mutex to deliver them before the messages published later,
+	// but write them after releasing it
+	conn.queueReply(query, "ok", pdu.SubscribeOk{
+		Position:       position,
+		SubscriptionId: subscriptionId,
+	})
+	for _, m := range replay {
+		conn.queueData(subscriptionId, m)
+	}
+	s.mutex.Unlock()
+
+	conn.flush()
+}
+
+func (s *Server) handleUnsubscribe(conn *Conn, query pdu.RTMQuery) {
+	var body pdu.UnsubscribeBody
+	if err := json.Unmarshal(query.Body, &body); err != nil {
+		conn.ReplyError(query, "invalid_format", err.Error())
+		return
+	}
+
+	conn.mutex.Lock()
+	_, ok := conn.subscriptions[body.SubscriptionId]
+	delete(conn.subscriptions, body.SubscriptionId)
+	conn.mutex.Unlock()
+
+	if !ok {
+		conn.replySubscribeError(query, body.SubscriptionId, "not_subscribed", "Subscription not found")
+		return
+	}
+
+	s.mutex.Lock()
+	position := s.currentPosition()
+	s.mutex.Unlock()
+
+	conn.Reply(query, "ok", pdu.UnsubscribeBodyResponse{
+		Position:       position,
+		SubscriptionId: body.SubscriptionId,
+	})
+}
+
+// Unmarshals the body and checks the access to the channel.
+// Replies with an error PDU and returns false if the request cannot be processed
+func (s *Server) parseChannelBody(conn *Conn, query pdu.RTMQuery, body interface{}, channel *string) bool {
+	if err := json.Unmarshal(query.Body, body); err != nil {
+		conn.ReplyError(query, "invalid_format", err.Error())
+		return false
+	}
+	if len(*channel) == 0 {
+		conn.ReplyError(query, "invalid_format", "Channel is missing")
+		return false
+	}
+	if !conn.isAllowed(*channel) {
+		conn.ReplyError(query, "authorization_denied", "Unauthorized")
+		return false
+	}
+	return true
+}
+
+func (s *Server) publish(channel string, message json.RawMessage, deleted bool) string {
+	var subscribers []*Conn
+	defer func() {
+		for _, conn := range subscribers {
+			conn.flush()
+		}
+	}()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastSeq++
+	stored := storedMessage{
+		seq:      s.lastSeq,
+		position: s.currentPosition(),
+		message:  message,
+	}
+
+	ch, ok := s.channels[channel]
+	if !ok {
+		ch = &channelType{}
+		s.channels[channel] = ch
+	}
+	if ch.ttl != nil {
+		ch.ttl.Stop()
+		ch.ttl = nil
+	}
+	ch.history = append(ch.history, stored)
+	if len(ch.history) > MAX_HISTORY_LENGTH {
+		ch.history = ch.history[1:]
+	}
+	if deleted {
+		ch.value = nil
+	} else {
+		ch.value = &stored
+	}
+
+	// The messages are written by the deferred flush after the server mutex is released
+	for conn := range s.conns {
+		subscriptionIds := conn.subscriptionsFor(channel)
+		for _, subscriptionId := range subscriptionIds {
+			conn.queueData(subscriptionId, stored)
+		}
+		if len(subscriptionIds) != 0 {
+			subscribers = append(subscribers, conn)
+		}
+	}
+
+	return stored.position
+}
+
+// Publishes the TTL message to the channel if nothing is published within ttl
+func (s *Server) expireAfter(channel string, ttl time.Duration, message json.RawMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ch := s.channels[channel]
+	var timer *time.Timer
+	timer = time.AfterFunc(ttl, func() {
+		s.mutex.Lock()
+		expired := ch.ttl == timer
+		s.mutex.Unlock()
+		if expired {
+			s.publish(channel, message, false)
+		}
+	})
+	ch.ttl = timer
+}
+
+// Should be called under the server mutex
+func (s *Server) currentPosition() string {
+	return strconv.FormatInt(s.epoch, 10) + ":" + strconv.FormatInt(s.lastSeq, 10)
+}
+
+func (s *Server) parsePosition(position string) (int64, error) {
+	parsed, err := pdu.ParsePosition(position)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid position: %s", position)
+	}
+	return int64(parsed.Sequence), nil
+}
+
+// Sends a PDU to the client
+func (c *Conn) Send(query pdu.RTMQuery) error {
+	if err := c.queue(query); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// Sends a response for the query. Outcome is appended to the query action, e.g. "ok" or "error".
+// Does nothing if the query has no id
+func (c *Conn) Reply(query pdu.RTMQuery, outcome string, body interface{}) error {
+	if err := c.queueReply(query, outcome, body); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// Sends an error response for the query
+func (c *Conn) ReplyError(query pdu.RTMQuery, code, reason string) error {
+	return c.Reply(query, "error", pdu.Error{
+		Error:  code,
+		Reason: reason,
+	})
+}
+
+// Closes the connection without sending a close frame
+func (c *Conn) Close() error {
+	return c.wsConn.Close()
+}
+
+// Returns the codec negotiated with the client
+func (c *Conn) Codec() pdu.Codec {
+	return c.codec
+}
+
+// Returns the HTTP headers of the WebSocket handshake request
+func (c *Conn) Header() http.Header {
+	return c.header
+}
+
+// Checks if the connection is authenticated with the role
+func (c *Conn) IsAuthenticated() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.authenticated
+}
+
+func (c *Conn) replySubscribeError(query pdu.RTMQuery, subscriptionId, code, reason string) {
+	c.Reply(query, "error", pdu.SubscribeError{
+		Error:          code,
+		Reason:         reason,
+		SubscriptionId: subscriptionId,
+	})
+}
+
+// Appends the PDU to the outgoing queue. PDUs are written to the socket in the order they were queued
+func (c *Conn) queue(query pdu.RTMQuery) error {
+	message, err := c.codec.Marshal(&query)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.outgoing = append(c.outgoing, message)
+	c.mutex.Unlock()
+	return nil
+}
+
+// Writes the queued PDUs. Must not be called under the server mutex: the write blocks until the client reads the data
+func (c *Conn) flush() error {
+	messageType := websocket.TextMessage
+	if c.codec.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+
+	c.wSockMutex.Lock()
+	defer c.wSockMutex.Unlock()
+	for {
+		c.mutex.Lock()
+		outgoing := c.outgoing
+		c.outgoing = nil
+		c.mutex.Unlock()
+
+		if len(outgoing) == 0 {
+			return nil
+		}
+		for _, message := range outgoing {
+			if err := c.wsConn.WriteMessage(messageType, message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Conn) queueReply(query pdu.RTMQuery, outcome string, body interface{}) error {
+	if len(query.Id) == 0 {
+		return nil
+	}
+
+	rawBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return c.queue(pdu.RTMQuery{
+		Action: query.Action + "/" + outcome,
+		Body:   rawBody,
+		Id:     query.Id,
+	})
+}
+
+func (c *Conn) queueData(subscriptionId string, m storedMessage) {
+	body, _ := json.Marshal(pdu.SubscriptionData{
+		Position:       m.position,
+		Messages:       []json.RawMessage{m.message},
+		SubscriptionId: subscriptionId,
+	})
+	c.queue(pdu.RTMQuery{
+		Action: "rtm/subscription/data",
+		Body:   body,
+	})
+}
+
+func (c *Conn) subscriptionsFor(channel string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var ids []string
+	for subscriptionId, ch := range c.subscriptions {
+		if ch == channel {
+			ids = append(ids, subscriptionId)
+		}
+	}
+	return ids
+}
+
+func (c *Conn) isAllowed(channel string) bool {
+	c.server.mutex.Lock()
+	restricted := c.server.restricted[channel]
+	c.server.mutex.Unlock()
+
+	return !restricted || c.IsAuthenticated()
+}
+
+func hmacMD5(message, secret string) string {
+	h := hmac.New(md5.New, []byte(secret))
+	h.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,244 @@
+package rtmtest
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	t      *testing.T
+	wsConn *websocket.Conn
+	lastID int
+}
+
+func dial(t *testing.T, s *Server) *testClient {
+	wsConn, _, err := websocket.DefaultDialer.Dial(s.URL+"/v2?appkey=test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, wsConn: wsConn}
+}
+
+func (c *testClient) send(query pdu.RTMQuery) {
+	if err := c.wsConn.WriteJSON(query); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() pdu.RTMQuery {
+	var query pdu.RTMQuery
+	c.wsConn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := c.wsConn.ReadJSON(&query); err != nil {
+		c.t.Fatal(err)
+	}
+	return query
+}
+
+// Sends the request and waits for the response with the same id
+func (c *testClient) request(action string, body string) pdu.RTMQuery {
+	c.lastID++
+	id := strconv.Itoa(c.lastID)
+	c.send(pdu.RTMQuery{
+		Action: action,
+		Body:   json.RawMessage(body),
+		Id:     id,
+	})
+	for {
+		if query := c.read(); query.Id == id {
+			return query
+		}
+	}
+}
+
+func (c *testClient) Close() {
+	c.wsConn.Close()
+}
+
+func TestWriteRead(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn := dial(t, s)
+	defer conn.Close()
+
+	response := conn.request("rtm/write", `{"channel":"kv","message":{"a":1}}`)
+	if response.Action != "rtm/write/ok" {
+		t.Fatal("Unexpected response: " + response.String())
+	}
+	var written pdu.WriteBodyResponse
+	json.Unmarshal(response.Body, &written)
+
+	response = conn.request("rtm/read", `{"channel":"kv"}`)
+	var read pdu.ReadBodyResponse
+	json.Unmarshal(response.Body, &read)
+	if string(read.Message) != `{"a":1}` || read.Position != written.Position {
+		t.Fatal("Unexpected read response: " + response.String())
+	}
+
+	conn.request("rtm/delete", `{"channel":"kv"}`)
+	response = conn.request("rtm/read", `{"channel":"kv"}`)
+	json.Unmarshal(response.Body, &read)
+	if string(read.Message) != "null" {
+		t.Fatal("Value was not deleted: " + response.String())
+	}
+}
+
+func TestSubscribeHistory(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Publish("history", json.RawMessage("1"))
+	s.Publish("history", json.RawMessage("2"))
+	s.Publish("history", json.RawMessage("3"))
+
+	conn := dial(t, s)
+	defer conn.Close()
+
+	conn.send(pdu.RTMQuery{
+		Action: "rtm/subscribe",
+		Body:   json.RawMessage(`{"channel":"history","history":{"count":2}}`),
+		Id:     "1",
+	})
+
+	expected := []string{"rtm/subscribe/ok", "2", "3"}
+	for _, e := range expected {
+		query := conn.read()
+		if query.Action == "rtm/subscription/data" {
+			var data pdu.SubscriptionData
+			json.Unmarshal(query.Body, &data)
+			if len(data.Messages) != 1 || string(data.Messages[0]) != e {
+				t.Fatal("Unexpected data: " + query.String())
+			}
+		} else if query.Action != e {
+			t.Fatal("Unexpected PDU: " + query.String())
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddRole("role", "secret")
+	s.RestrictChannel("restricted")
+
+	conn := dial(t, s)
+	defer conn.Close()
+
+	response := conn.request("rtm/publish", `{"channel":"restricted","message":1}`)
+	if response.Action != "rtm/publish/error" {
+		t.Fatal("Published to restricted channel without authentication")
+	}
+
+	response = conn.request("auth/handshake", `{"method":"role_secret","data":{"role":"role"}}`)
+	var nonce struct {
+		Data struct {
+			Nonce string `json:"nonce"`
+		} `json:"data"`
+	}
+	json.Unmarshal(response.Body, &nonce)
+
+	hash := hmacMD5(nonce.Data.Nonce, "secret")
+	response = conn.request("auth/authenticate", `{"method":"role_secret","credentials":{"hash":"`+hash+`"}}`)
+	if response.Action != "auth/authenticate/ok" {
+		t.Fatal("Unable to authenticate: " + response.String())
+	}
+
+	response = conn.request("rtm/publish", `{"channel":"restricted","message":1}`)
+	if response.Action != "rtm/publish/ok" {
+		t.Fatal("Unable to publish to restricted channel: " + response.String())
+	}
+}
+
+func TestHandleFunc(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.HandleFunc("rtm/publish", func(conn *Conn, query pdu.RTMQuery) {
+		conn.ReplyError(query, "custom_error", "Injected")
+	})
+
+	conn := dial(t, s)
+	defer conn.Close()
+
+	response := conn.request("rtm/publish", `{"channel":"ch","message":1}`)
+	if response.Action != "rtm/publish/error" {
+		t.Fatal("Custom handler was not called: " + response.String())
+	}
+
+	s.HandleFunc("rtm/publish", nil)
+	response = conn.request("rtm/publish", `{"channel":"ch","message":1}`)
+	if response.Action != "rtm/publish/ok" {
+		t.Fatal("Default handler was not restored: " + response.String())
+	}
+}
+
+func TestUnknownAction(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn := dial(t, s)
+	defer conn.Close()
+
+	response := conn.request("test", "{}")
+	if response.Action != "test/error" {
+		t.Fatal("Unexpected response: " + response.String())
+	}
+}
+
+func TestSubscriptionInfoError(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	conn := dial(t, s)
+	defer conn.Close()
+
+	if response := conn.request("rtm/subscribe", `{"channel":"sync"}`); response.Action != "rtm/subscribe/ok" {
+		t.Fatal("Unexpected PDU: " + response.String())
+	}
+
+	s.SubscriptionInfo("sync", "fast_forward", "Subscription was fast-forwarded")
+	if query := conn.read(); query.Action != "rtm/subscription/info" || !strings.Contains(string(query.Body), `"subscription_id":"sync"`) {
+		t.Fatal("Unexpected PDU: " + query.String())
+	}
+
+	s.SubscriptionError("sync", "out_of_sync", "Too much traffic")
+	query := conn.read()
+	var subscriptionError pdu.SubscriptionError
+	json.Unmarshal(query.Body, &subscriptionError)
+	if query.Action != "rtm/subscription/error" || subscriptionError.Error != "out_of_sync" || len(subscriptionError.Position) == 0 {
+		t.Fatal("Unexpected PDU: " + query.String())
+	}
+
+	// The subscription is dropped after the error
+	if response := conn.request("rtm/unsubscribe", `{"subscription_id":"sync"}`); response.Action != "rtm/unsubscribe/error" {
+		t.Fatal("Unexpected PDU: " + response.String())
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	slow := dial(t, s)
+	defer slow.Close()
+	if response := slow.request("rtm/subscribe", `{"channel":"slow"}`); response.Action != "rtm/subscribe/ok" {
+		t.Fatal("Unexpected PDU: " + response.String())
+	}
+
+	// The slow client does not read, so writing to its socket blocks once the buffers are full
+	message := json.RawMessage(`"` + strings.Repeat("a", 1<<20) + `"`)
+	go func() {
+		for i := 0; i < 256; i++ {
+			s.Publish("slow", message)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	conn := dial(t, s)
+	defer conn.Close()
+	if response := conn.request("rtm/publish", `{"channel":"fast","message":1}`); response.Action != "rtm/publish/ok" {
+		t.Fatal("Unexpected PDU: " + response.String())
+	}
+}
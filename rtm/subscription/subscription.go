@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"github.com/satori-com/satori-rtm-sdk-go/logger"
 	"github.com/satori-com/satori-rtm-sdk-go/rtm/pdu"
+	"sync"
 )
 
 const (
@@ -90,6 +91,10 @@ type Subscription struct {
 	position       string
 	body           pdu.SubscribeBody
 	listener       Listener
+
+	// Guards state, position and body. Subscription is updated from the client event queue
+	// and from the goroutines that wait for RTM responses
+	mutex sync.Mutex
 }
 
 func New(config Config) *Subscription {
@@ -104,6 +109,7 @@ func New(config Config) *Subscription {
 	s.body.History = opts.History
 	s.body.Period = opts.Period
 	s.body.Position = opts.Position
+	s.body.Only = opts.Only
 
 	s.body.FastForward = s.mode.fastForward
 
@@ -127,11 +133,13 @@ func (s *Subscription) SubscribePdu() pdu.RTMQuery {
 		Action: "rtm/subscribe",
 	}
 
+	s.mutex.Lock()
 	if len(s.position) != 0 {
 		s.body.Position = s.position
 	}
 
 	query.Body, _ = json.Marshal(s.body)
+	s.mutex.Unlock()
 
 	return query
 }
@@ -150,8 +158,10 @@ func (s *Subscription) UnsubscribePdu() pdu.RTMQuery {
 
 func (s *Subscription) ProcessSubscribe(data pdu.SubscribeOk) {
 	s.trackPosition(data.Position)
+	s.mutex.Lock()
 	s.state = STATE_SUBSCRIBED
 	s.body.Position = ""
+	s.mutex.Unlock()
 
 	if s.listener.OnSubscribed != nil {
 		defer s.catchCallbackPanic()
@@ -220,9 +230,12 @@ func (s *Subscription) ProcessData(data pdu.SubscriptionData) {
 }
 
 func (s *Subscription) markUnsubscribe(data pdu.UnsubscribeBodyResponse) {
-	if s.state == STATE_SUBSCRIBED {
-		s.state = STATE_UNSUBSCRIBED
+	s.mutex.Lock()
+	wasSubscribed := s.state == STATE_SUBSCRIBED
+	s.state = STATE_UNSUBSCRIBED
+	s.mutex.Unlock()
 
+	if wasSubscribed {
 		if s.listener.OnUnsubscribed != nil {
 			defer s.catchCallbackPanic()
 			s.listener.OnUnsubscribed(data)
@@ -233,7 +246,9 @@ func (s *Subscription) markUnsubscribe(data pdu.UnsubscribeBodyResponse) {
 // Stores current position
 func (s *Subscription) trackPosition(position string) {
 	if s.mode.trackPosition {
+		s.mutex.Lock()
 		s.position = position
+		s.mutex.Unlock()
 	}
 
 	if s.listener.OnPosition != nil {
@@ -244,6 +259,8 @@ func (s *Subscription) trackPosition(position string) {
 
 // Gets current subscription state
 func (s *Subscription) GetState() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	return s.state
 }
 
@@ -7,7 +7,7 @@ import (
 )
 
 // Creates new listener instance and specifies several callbacks
-func ExampleNewListener() {
+func ExampleListener() {
 	listener := Listener{
 		OnData: func(data pdu.SubscriptionData) {
 			// Got messages
@@ -48,6 +48,23 @@ func TestSubscribePdu(t *testing.T) {
 	}
 }
 
+func TestSubscribePdu_OnlyValue(t *testing.T) {
+	sub := New(Config{
+		SubscriptionId: "test",
+		Mode:           SIMPLE,
+		Opts: pdu.SubscribeBodyOpts{
+			Only: pdu.ONLY_VALUE,
+		},
+	})
+	subPdu := sub.SubscribePdu()
+
+	var subBody pdu.SubscribeBody
+	json.Unmarshal(subPdu.Body, &subBody)
+	if subBody.Only != "value" {
+		t.Errorf("Unexpected body: %s", subPdu.Body)
+	}
+}
+
 func TestUnsubscribePdu(t *testing.T) {
 	sub := New(Config{
 		SubscriptionId: "test",